@@ -2,12 +2,16 @@ package api
 
 import (
 	v1 "app/api/v1"
+	"app/util"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 func ApplyRoutes(app *gin.Engine) {
+	app.GET(".well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, util.JWKS())
+	})
 	api := app.Group("api")
 	{
 		v1.ApplyRoutes(api)
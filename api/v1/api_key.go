@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"app/repository/dto"
+	"app/util"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyAuthUserID returns the user id of auth, api keys themselves can't manage keys
+func keyAuthUserID(c *gin.Context) (string, error) {
+	auth := c.GetStringMap("auth")
+	if _, ok := auth["apiKey"]; ok {
+		return "", errors.New("不可使用API密钥管理密钥")
+	}
+	return auth["id"].(string), nil
+}
+
+func apiKeys(c *gin.Context) {
+	id, err := keyAuthUserID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	rows, err := dto.FindAPIKeys(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(rows))
+}
+
+func createAPIKey(c *gin.Context) {
+	var body dto.NewAPIKey
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	id, err := keyAuthUserID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	created, key, err := body.Create(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
+		"apiKey": created, "key": key,
+	}))
+}
+
+func revokeAPIKey(c *gin.Context) {
+	id, err := keyAuthUserID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := dto.RevokeAPIKey(id, c.Param("id")); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
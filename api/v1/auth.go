@@ -2,6 +2,7 @@ package v1
 
 import (
 	"app/lib/config"
+	"app/lib/ws"
 	"app/repository/dao"
 	"app/repository/dto"
 	"app/util"
@@ -22,21 +23,72 @@ func register(c *gin.Context) {
 		_ = c.Error(errors.New("用户已存在"))
 		return
 	}
-	created, err := body.Create()
+	exists, _ = dao.FindByEmail(body.Email)
+	if exists {
+		_ = c.Error(errors.New("邮箱已被使用"))
+		return
+	}
+	created, err := body.Create(config.App.DefaultRole, config.Mail.VerifyURL)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	token, err := util.GenerateToken(config.App.JWTSecret, map[string]interface{}{
-		"id": created.ID, "username": created.Username,
-	})
+	c.JSON(http.StatusOK, util.Reply(created))
+}
+
+func verifyEmail(c *gin.Context) {
+	var body dto.VerifyEmail
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	verified, err := body.Verify()
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
-		"user": created, "token": token,
-	}))
+	c.JSON(http.StatusOK, util.Reply(verified))
+}
+
+func resendVerification(c *gin.Context) {
+	var body dto.ResendVerification
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := body.Resend(config.Mail.VerifyURL); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func forgotPassword(c *gin.Context) {
+	var body dto.ForgotPassword
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := body.Forgot(config.Mail.ResetURL); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func resetPasswordByToken(c *gin.Context) {
+	var body dto.ResetPasswordByToken
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	updated, err := body.Reset()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ws.WebsocketServer.CloseUser(updated.ID)
+	c.JSON(http.StatusOK, util.Reply(updated))
 }
 
 func login(c *gin.Context) {
@@ -45,27 +97,130 @@ func login(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	found, err := body.Login()
+	found, err := body.Login(c.ClientIP())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	granted, err := signIn(c, found)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(granted))
+}
+
+// signIn grant tokens to user whose first factor passed, users with totp enabled
+// get a mfa token to exchange through login/mfa instead
+func signIn(c *gin.Context, user dao.User) (map[string]interface{}, error) {
+	if user.TOTPEnabled {
+		mfaToken, err := dto.MFAToken(user)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"mfaRequired": true, "mfaToken": mfaToken}, nil
+	}
+	return grantToken(c, user, false)
+}
+
+func loginMFA(c *gin.Context) {
+	var body dto.VerifyMFA
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	found, err := body.Verify(c.ClientIP())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	granted, err := grantToken(c, found, true)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	if !found.IsActived {
-		_ = c.Error(errors.New("用户未激活"))
+	c.JSON(http.StatusOK, util.Reply(granted))
+}
+
+func refresh(c *gin.Context) {
+	var body dto.RefreshToken
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	found, next, refreshToken, err := body.Refresh(config.App.RefreshTokenTTL, c.ClientIP())
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
-	token, err := util.GenerateToken(config.App.JWTSecret, map[string]interface{}{
-		"id": found.ID, "username": found.Username,
-	})
+	token, err := accessToken(found, next.Family, next.MFA)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
-		"user": found, "token": token,
+		"user": found, "token": token, "refreshToken": refreshToken,
+		"expiresIn": int64(config.App.AccessTokenTTL.Seconds()),
 	}))
 }
 
+func logout(c *gin.Context) {
+	var body dto.Logout
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	sid, err := body.Logout(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ws.WebsocketServer.CloseSession(id, sid)
+	c.Status(http.StatusNoContent)
+}
+
+// accessToken sign claims of user within session sid, amr lists the authentication
+// methods the login went through so that middleware can tell second factor logins
+func accessToken(user dao.User, sid string, mfa bool) (string, error) {
+	roles, err := user.RoleNames()
+	if err != nil {
+		return "", err
+	}
+	amr := []string{"pwd"}
+	if mfa {
+		amr = append(amr, "otp")
+	}
+	return util.GenerateToken(map[string]interface{}{
+		"id": user.ID, "username": user.Username, "roles": roles, "amr": amr, "sid": sid,
+	}, config.App.AccessTokenTTL)
+}
+
+// grantToken start a session for the client logging in as user and issue
+// an access token along with a refresh token whose family is the session
+func grantToken(c *gin.Context, user dao.User, mfa bool) (map[string]interface{}, error) {
+	body := dto.NewSession{
+		Device: c.GetHeader("X-Device-Name"), UserAgent: c.Request.UserAgent(), IP: c.ClientIP(),
+	}
+	session, err := body.Create(user.ID, mfa)
+	if err != nil {
+		return nil, err
+	}
+	token, err := accessToken(user, session.ID, mfa)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, _, err := dao.IssueRefreshToken(nil, user.ID, session.ID, mfa, config.App.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"user": user, "token": token, "refreshToken": refreshToken,
+		"expiresIn": int64(config.App.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
 func changePassword(c *gin.Context) {
 	var body dto.ChangePassword
 	if err := c.ShouldBind(&body); err != nil {
@@ -74,7 +229,11 @@ func changePassword(c *gin.Context) {
 	}
 	auth := c.GetStringMap("auth")
 	id := auth["id"].(string)
-	updated, err := body.ChangePassword(id)
+	sid, _ := auth["sid"].(string)
+	updated, revoked, err := body.ChangePassword(id, sid)
+	for _, revokedID := range revoked {
+		ws.WebsocketServer.CloseSession(id, revokedID)
+	}
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -94,6 +253,7 @@ func resetPassword(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
+	ws.WebsocketServer.CloseUser(id)
 	c.JSON(http.StatusOK, util.Reply(updated))
 }
 
@@ -1,9 +1,11 @@
 package v1
 
 import (
+	"app/middleware"
 	"app/repository/dao"
 	"app/repository/dto"
 	"app/util"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -16,9 +18,12 @@ func createCategory(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	auth := c.GetStringMap("auth")
-	id := auth["id"].(string)
-	created, err := body.Create(id)
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	created, err := body.Create(owner)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -37,7 +42,12 @@ func updateCategory(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	saved, err := body.Save(uint(id))
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	saved, err := body.Save(uint(id), owner)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -51,12 +61,17 @@ func deleteCategory(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	err := body.Delete()
+	owner, err := categoryOwner(c, dao.PermissionCategoryDelete)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	c.Status(http.StatusNoContent)
+	report, err := body.Delete(owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(report))
 }
 
 func category(c *gin.Context) {
@@ -65,23 +80,84 @@ func category(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	found, err := dao.FindCategoryHierarchy(uint(id), map[string]interface{}{
+	var query dto.QueryCategoryTree
+	if err := c.ShouldBind(&query); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	found, err := dao.FindCategoryHierarchy(uint(id), query.Depth, map[string]interface{}{
 		"preload": []string{"Posts"},
 	})
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
+	if !canViewCategory(c, found) {
+		_ = c.Error(errors.New("分类不存在"))
+		return
+	}
 	c.JSON(http.StatusOK, util.Reply(found))
 }
 
+// canViewCategory tell whether the current user may see category, besides the shared tree
+// only the owner may see a tree
+func canViewCategory(c *gin.Context, category dao.Category) bool {
+	userID, _ := c.GetStringMap("auth")["id"].(string)
+	return category.OwnerID == "" || category.OwnerID == userID
+}
+
+// categoryOwner tell whose tree the current request writes, the own tree of the user by default,
+// or the shared tree of categories without owner when asked with ?tree=shared, which takes the
+// category:manage permission and any extra perms the action needs. Categories created before trees
+// were scoped by owner stay in the shared tree
+func categoryOwner(c *gin.Context, perms ...string) (string, error) {
+	if c.Query("tree") == "shared" {
+		allowed, err := middleware.Allowed(c, append([]string{dao.PermissionCategoryManage}, perms...)...)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return "", errors.New("没有权限")
+		}
+		return "", nil
+	}
+	return c.GetStringMap("auth")["id"].(string), nil
+}
+
+func categoryPath(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	found, err := dao.FindCategory(uint(id), nil)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if !canViewCategory(c, found) {
+		_ = c.Error(errors.New("分类不存在"))
+		return
+	}
+	rows, err := found.Path()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(rows))
+}
+
 func categories(c *gin.Context) {
 	var query dto.QueryCategory
 	if err := c.ShouldBind(&query); err != nil {
 		_ = c.Error(err)
 		return
 	}
-	rows, count, err := query.Find()
+	userID, _ := c.GetStringMap("auth")["id"].(string)
+	if c.Query("tree") == "shared" {
+		userID = ""
+	}
+	rows, count, err := query.Find(userID)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -103,12 +179,12 @@ func moveCategory(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	parent, err := dao.FindCategory(uint(parentID), nil)
+	owner, err := categoryOwner(c)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	err = body.Move(&parent)
+	err = body.Move(uint(parentID), owner)
 	// err = folder.MoveTo(&parent)
 	if err != nil {
 		_ = c.Error(err)
@@ -117,13 +193,66 @@ func moveCategory(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+func reorderCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var body dto.ReorderCategory
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	moved, err := body.Reorder(uint(id), owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(moved))
+}
+
+func copyCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var body dto.CopyCategory
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	copied, err := body.Copy(uint(id), owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(copied))
+}
+
 func addToCategory(c *gin.Context) {
 	var body dto.IOCategory
 	if err := c.ShouldBind(&body); err != nil {
 		_ = c.Error(err)
 		return
 	}
-	folder, err := body.In()
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	folder, err := body.In(owner)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -137,7 +266,12 @@ func removeFromCategory(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	folder, err := body.Out()
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	folder, err := body.Out(owner)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -151,10 +285,41 @@ func movePost(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	folder, err := body.Move()
+	owner, err := categoryOwner(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	folder, err := body.Move(owner)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, util.Reply(folder))
 }
+
+func verifyCategories(c *gin.Context) {
+	report, err := dao.VerifyCategories()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(report))
+}
+
+// rebuildCategories rebuild every category tree, each one is locked only while it is rebuilt
+func rebuildCategories(c *gin.Context) {
+	updated, err := dao.RebuildCategories()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	report, err := dao.VerifyCategories()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
+		"updated": updated, "report": report,
+	}))
+}
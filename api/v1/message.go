@@ -1,7 +1,11 @@
 package v1
 
 import (
+	"app/lib/config"
 	"app/lib/ws"
+	"app/repository/dao"
+	"app/repository/dto"
+	"app/util"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,23 +13,81 @@ import (
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: ws.CheckOrigin(func() []string { return config.Websocket.AllowedOrigins }),
+	// echoed back to clients passing their token as "bearer, <token>" subprotocols
+	Subprotocols: []string{"bearer"},
 }
 
 func ConnectWebsocket(c *gin.Context) {
+	auth := c.GetStringMap("auth")
+	userID := auth["id"].(string)
 	unsafeConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	token := c.Query("token")
-	ws.WebsocketServer.RegisterConn(token, unsafeConn)
-	// util.NewWebsocketConnection(token, unsafeConn)
-	c.Status(http.StatusNoContent)
+	sid, _ := auth["sid"].(string)
+	ws.WebsocketServer.RegisterConn(userID, sid, unsafeConn)
+}
+
+// streamEvents stream the messages websocket clients get as server-sent events,
+// topics are followed through repeated ?topic= params
+func streamEvents(c *gin.Context) {
+	auth := c.GetStringMap("auth")
+	userID := auth["id"].(string)
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventID")
+	}
+	sid, _ := auth["sid"].(string)
+	err := ws.WebsocketServer.ServeStream(c.Writer, c.Request, userID, sid, c.QueryArray("topic"), lastEventID)
+	if err != nil {
+		_ = c.Error(err)
+	}
 }
 
+// DisconnectWebsocket close every websocket connection of current user
 func DisconnectWebsocket(c *gin.Context) {
-	token := c.Query("token")
-	ws.WebsocketServer.UnRegisterConn(token)
+	auth := c.GetStringMap("auth")
+	userID := auth["id"].(string)
+	ws.WebsocketServer.CloseUser(userID)
+	c.Status(http.StatusNoContent)
+}
+
+func notifications(c *gin.Context) {
+	var query dto.QueryNotification
+	if err := c.ShouldBind(&query); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	auth := c.GetStringMap("auth")
+	userID := auth["id"].(string)
+	rows, count, err := query.Find(userID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	unread, err := dao.CountUnreadNotifications(userID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
+		"count": count, "unread": unread, "rows": rows,
+	}))
+}
+
+func readNotifications(c *gin.Context) {
+	var body dto.ReadNotification
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	auth := c.GetStringMap("auth")
+	userID := auth["id"].(string)
+	if err := body.Read(userID); err != nil {
+		_ = c.Error(err)
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,72 @@
+package v1
+
+import (
+	"app/lib/config"
+	"app/repository/dto"
+	"app/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func enrollTOTP(c *gin.Context) {
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	enrolled, err := dto.EnrollTOTP(id, config.App.Name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(enrolled))
+}
+
+func confirmTOTP(c *gin.Context) {
+	var body dto.ConfirmTOTP
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	codes, err := body.Confirm(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
+		"recoveryCodes": codes,
+	}))
+}
+
+func disableTOTP(c *gin.Context) {
+	var body dto.DisableTOTP
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	if err := body.Disable(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func regenerateRecoveryCodes(c *gin.Context) {
+	var body dto.RegenerateRecoveryCodes
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	codes, err := body.Regenerate(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
+		"recoveryCodes": codes,
+	}))
+}
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"app/lib/config"
+	"app/lib/oauth"
+	"app/repository/dto"
+	"app/util"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const oauthStateCookie = "oauth_state"
+
+var errOAuthProvider = errors.New("不支持的登录方式")
+
+func oauthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, util.Reply(oauth.Names()))
+}
+
+// oauthRedirect start the authorization code flow, state, nonce and pkce verifier
+// are signed into a short-lived cookie which the callback checks
+func oauthRedirect(c *gin.Context) {
+	provider, ok := oauth.Find(c.Param("provider"))
+	if !ok {
+		_ = c.Error(errOAuthProvider)
+		return
+	}
+	req, err := oauth.NewAuthRequest()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	state, err := dto.OAuthState(provider.Name(), req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	location, err := provider.AuthCodeURL(c.Request.Context(), req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, 600, c.Request.URL.Path, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, location)
+}
+
+func oauthCallback(c *gin.Context) {
+	provider, ok := oauth.Find(c.Param("provider"))
+	if !ok {
+		_ = c.Error(errOAuthProvider)
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		_ = c.Error(errors.New("授权失败: " + reason))
+		return
+	}
+	cookie, _ := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, strings.TrimSuffix(c.Request.URL.Path, "/callback"), "", c.Request.TLS != nil, true)
+	req, err := dto.DecodeOAuthState(cookie, provider.Name(), c.Query("state"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	found, err := dto.OAuthLogin(identity, config.App.DefaultRole)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	granted, err := signIn(c, found)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(granted))
+}
@@ -32,7 +32,8 @@ func updatePost(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	saved, err := body.Save(id)
+	auth := c.GetStringMap("auth")
+	saved, err := body.Save(id, auth["id"].(string))
 	if err != nil {
 		_ = c.Error(err)
 		return
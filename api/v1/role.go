@@ -0,0 +1,96 @@
+package v1
+
+import (
+	"app/repository/dao"
+	"app/repository/dto"
+	"app/util"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func roles(c *gin.Context) {
+	rows, err := dao.FindRoles(map[string]interface{}{
+		"preload": []string{"Permissions"},
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(rows))
+}
+
+func permissions(c *gin.Context) {
+	rows, err := dao.FindPermissions(nil)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(rows))
+}
+
+func createRole(c *gin.Context) {
+	var body dto.NewRole
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	created, err := body.Create()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(created))
+}
+
+func updateRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var body dto.UpdateRole
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	saved, err := body.Save(uint(id))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(saved))
+}
+
+func deleteRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	found, err := dao.FindRole(uint(id), nil)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := found.Delete(); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func assignRoles(c *gin.Context) {
+	var body dto.AssignRoles
+	if err := c.ShouldBind(&body); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	updated, err := body.Assign(c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, util.Reply(updated))
+}
@@ -0,0 +1,57 @@
+package v1
+
+import (
+	"app/lib/ws"
+	"app/repository/dao"
+	"app/repository/dto"
+	"app/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func sessions(c *gin.Context) {
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	rows, err := dto.FindSessions(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	current, _ := auth["sid"].(string)
+	c.JSON(http.StatusOK, util.Reply(map[string]interface{}{
+		"current": current, "rows": rows,
+	}))
+}
+
+func revokeSession(c *gin.Context) {
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	if err := dto.RevokeSession(id, c.Param("id")); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ws.WebsocketServer.CloseSession(id, c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
+
+func revokeSessions(c *gin.Context) {
+	auth := c.GetStringMap("auth")
+	id := auth["id"].(string)
+	if err := dto.RevokeSessions(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ws.WebsocketServer.CloseUser(id)
+	c.Status(http.StatusNoContent)
+}
+
+func forceLogout(c *gin.Context) {
+	id := c.Param("id")
+	if err := dao.RevokeUserSessions([]string{id}); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ws.WebsocketServer.CloseUser(id)
+	c.Status(http.StatusNoContent)
+}
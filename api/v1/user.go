@@ -1,10 +1,13 @@
 package v1
 
 import (
+	"app/lib/config"
+	"app/lib/ws"
 	"app/repository/dao"
 	"app/repository/dto"
 	"app/util"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -43,7 +46,7 @@ func updateUser(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	updated, err := body.Save(id)
+	updated, err := body.Save(id, config.Mail.VerifyURL)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -58,6 +61,7 @@ func deleteUser(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
+	ws.WebsocketServer.CloseUser(id)
 	c.JSON(http.StatusOK, util.Reply(deleted))
 }
 
@@ -86,5 +90,8 @@ func deactiveUser(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
+	for _, id := range strings.Split(body.UserID, ",") {
+		ws.WebsocketServer.CloseUser(id)
+	}
 	c.Status(http.StatusNoContent)
 }
@@ -2,47 +2,102 @@ package v1
 
 import (
 	"app/middleware"
+	"app/repository/dao"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ApplyRoutes mount v1 routes, every group declares how it is authenticated:
+// public needs no token, optional reads a token when present, authorized requires one.
+// Api keys only reach routes registered through middleware.AcceptKeys, which check their scopes
 func ApplyRoutes(r *gin.RouterGroup) {
 	v1 := r.Group("v1")
+
+	public := v1.Group("public")
+	{
+		public.POST("register", register)
+		public.POST("login", login)
+		public.POST("login/mfa", loginMFA)
+		public.POST("refresh", refresh)
+		public.POST("email/verify", verifyEmail)
+		public.POST("email/resend", resendVerification)
+		public.POST("password/forgot", forgotPassword)
+		public.POST("password/reset", resetPasswordByToken)
+		public.GET("oauth", oauthProviders)
+		public.GET("oauth/:provider", oauthRedirect)
+		public.GET("oauth/:provider/callback", oauthCallback)
+	}
+
+	optional := v1.Group("public", middleware.OptionalAuthenticate())
+	{
+		optional.GET("post/:id", post)
+		optional.GET("post", middleware.Cache(), posts)
+
+		optional.GET("category/:id", category)
+		optional.GET("category/:id/path", categoryPath)
+		optional.GET("category", categories)
+	}
+
+	// browsers can't set headers on websocket handshakes and event streams,
+	// the token may come in query or subprotocols
+	streams := middleware.AcceptKeys(v1)
+	streams.GET("connect/message", middleware.StreamAuthenticate(), middleware.Scope(dao.ScopeEventRead), ConnectWebsocket)
+	streams.GET("events", middleware.StreamAuthenticate(), middleware.Scope(dao.ScopeEventRead), streamEvents)
+
+	authorized := v1.Group("", middleware.Authenticate())
+	keys := middleware.AcceptKeys(authorized)
 	{
-		v1.GET("ping", func(c *gin.Context) {
+		authorized.GET("ping", func(c *gin.Context) {
 			c.JSON(http.StatusOK, &gin.H{
 				"code": 0, "message": "pong",
 			})
 		})
-		v1.POST("public/register", register)
-		v1.POST("public/login", login)
-		v1.POST("change/password", changePassword)
-		v1.POST("reset/:id/password", resetPassword)
-		v1.GET("me", me)
-		v1.GET("user", users)
-		v1.GET("user/:id", user)
-		v1.PUT("user/:id", updateUser)
-		v1.DELETE("user/:id", deleteUser)
-		v1.POST("active/user", activeUser)
-		v1.DELETE("active/user", deactiveUser)
-
-		v1.GET("public/connect/message", ConnectWebsocket)
-		v1.GET("public/disconnect/message", DisconnectWebsocket)
-
-		v1.POST("post", createPost)
-		v1.PUT("post/:id", updatePost)
-		v1.GET("public/post/:id", post)
-		v1.GET("public/post", middleware.Cache(), posts)
-
-		v1.POST("category", createCategory)
-		v1.PUT("category/:id", updateCategory)
-		v1.GET("public/category/:id", category)
-		v1.GET("public/category", categories)
-		v1.DELETE("category", deleteCategory)
-		v1.POST("category/to/:id", moveCategory)
-		v1.POST("category/post", addToCategory)
-		v1.DELETE("category/post", removeFromCategory)
-		v1.PUT("category/post", movePost)
+		authorized.POST("logout", logout)
+		authorized.POST("disconnect/message", DisconnectWebsocket)
+		authorized.GET("notification", notifications)
+		authorized.PUT("notification/read", readNotifications)
+		authorized.POST("change/password", changePassword)
+		keys.POST("reset/:id/password", middleware.Permission(dao.PermissionUserResetPassword), resetPassword)
+		keys.GET("me", middleware.Scope(dao.ScopeUserRead), me)
+		authorized.POST("mfa/totp", enrollTOTP)
+		authorized.POST("mfa/totp/confirm", confirmTOTP)
+		authorized.DELETE("mfa/totp", disableTOTP)
+		authorized.POST("mfa/recovery-codes", regenerateRecoveryCodes)
+		authorized.GET("session", sessions)
+		authorized.DELETE("session/:id", revokeSession)
+		authorized.DELETE("session", revokeSessions)
+		authorized.GET("apikey", apiKeys)
+		authorized.POST("apikey", createAPIKey)
+		authorized.DELETE("apikey/:id", revokeAPIKey)
+		keys.GET("user", middleware.Scope(dao.ScopeUserRead), users)
+		keys.GET("user/:id", middleware.Scope(dao.ScopeUserRead), user)
+		keys.PUT("user/:id", middleware.SelfOrPermission("id", dao.PermissionUserUpdate), updateUser)
+		keys.DELETE("user/:id", middleware.Permission(dao.PermissionUserDelete), deleteUser)
+		keys.PUT("user/:id/role", middleware.Permission(dao.PermissionRoleManage), assignRoles)
+		keys.DELETE("user/:id/session", middleware.Permission(dao.PermissionSessionManage), forceLogout)
+		keys.POST("active/user", middleware.Permission(dao.PermissionUserActive), activeUser)
+		keys.DELETE("active/user", middleware.Permission(dao.PermissionUserActive), deactiveUser)
+
+		keys.GET("role", middleware.Permission(dao.PermissionRoleManage), roles)
+		keys.POST("role", middleware.Permission(dao.PermissionRoleManage), createRole)
+		keys.PUT("role/:id", middleware.Permission(dao.PermissionRoleManage), updateRole)
+		keys.DELETE("role/:id", middleware.Permission(dao.PermissionRoleManage), deleteRole)
+		keys.GET("permission", middleware.Permission(dao.PermissionRoleManage), permissions)
+
+		keys.POST("post", middleware.Scope(dao.ScopePostWrite), createPost)
+		keys.PUT("post/:id", middleware.Scope(dao.ScopePostWrite), updatePost)
+
+		keys.POST("category", middleware.Scope(dao.ScopeCategoryWrite), createCategory)
+		keys.PUT("category/:id", middleware.Scope(dao.ScopeCategoryWrite), updateCategory)
+		keys.DELETE("category", middleware.Scope(dao.ScopeCategoryWrite), deleteCategory)
+		keys.POST("category/to/:id", middleware.Scope(dao.ScopeCategoryWrite), moveCategory)
+		keys.POST("category/:id/reorder", middleware.Scope(dao.ScopeCategoryWrite), reorderCategory)
+		keys.POST("category/:id/copy", middleware.Scope(dao.ScopeCategoryWrite), copyCategory)
+		keys.GET("category/tree/verify", middleware.Permission(dao.PermissionCategoryManage), verifyCategories)
+		keys.POST("category/tree/rebuild", middleware.Permission(dao.PermissionCategoryManage), rebuildCategories)
+		keys.POST("category/post", middleware.Scope(dao.ScopeCategoryWrite), addToCategory)
+		keys.DELETE("category/post", middleware.Scope(dao.ScopeCategoryWrite), removeFromCategory)
+		keys.PUT("category/post", middleware.Scope(dao.ScopeCategoryWrite), movePost)
 	}
 }
@@ -4,37 +4,134 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 var App = new(AppConf)
 var Database = new(DatabaseConf)
+var JWT = new(JWTConf)
+var Mail = new(MailConf)
+var OAuth = new(OAuthConf)
+var Websocket = new(WebsocketConf)
 
 type AppConf struct {
-	Port           string `yaml:"port"`
-	JWTSecret      string `yaml:"jwtSecret"`
-	Locale         string `yaml:"locale"`
-	LogDir         string `yaml:"logDir"`
-	GroupAdminRole string `yaml:"groupAdminRole"`
-	DefaultRole    string `yaml:"defaultRole"`
-	DatabaseURL    string `yaml:"url"`
+	Name            string        `yaml:"name"`
+	Port            string        `yaml:"port"`
+	JWTSecret       string        `yaml:"jwtSecret"`
+	AccessTokenTTL  time.Duration `yaml:"accessTokenTTL"`
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL"`
+	Locale          string        `yaml:"locale"`
+	LogDir          string        `yaml:"logDir"`
+	GroupAdminRole  string        `yaml:"groupAdminRole"`
+	DefaultRole     string        `yaml:"defaultRole"`
+	DatabaseURL     string        `yaml:"url"`
+	AdminRequireMFA bool          `yaml:"adminRequireMFA"`
 }
 
 type DatabaseConf struct {
 	URL string `yaml:"url"`
 }
 
+type MailConf struct {
+	Driver    string `yaml:"driver"`
+	Host      string `yaml:"host"`
+	Port      int    `yaml:"port"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	From      string `yaml:"from"`
+	Dir       string `yaml:"dir"`
+	VerifyURL string `yaml:"verifyURL"`
+	ResetURL  string `yaml:"resetURL"`
+}
+
+type JWTKeyConf struct {
+	Kid        string `yaml:"kid"`
+	PrivateKey string `yaml:"privateKey"`
+	PublicKey  string `yaml:"publicKey"`
+}
+
+// JWTConf lists signing keys, Ephemeral lets development setups without keys or secret
+// sign with a key generated at startup
+type JWTConf struct {
+	SigningKey string       `yaml:"signingKey"`
+	Keys       []JWTKeyConf `yaml:"keys"`
+	Ephemeral  bool         `yaml:"ephemeral"`
+}
+
+// OAuthProviderConf configures an identity provider, Type is github, google or oidc
+type OAuthProviderConf struct {
+	Name         string   `yaml:"name"`
+	Type         string   `yaml:"type"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"clientID"`
+	ClientSecret string   `yaml:"clientSecret"`
+	RedirectURL  string   `yaml:"redirectURL"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+type OAuthConf struct {
+	Providers []OAuthProviderConf `yaml:"providers"`
+}
+
+// WebsocketConf lists origins browsers may open websockets from, "*" allows any.
+// Broker is memory, redis or nats and relays messages between nodes on Channel
+type WebsocketConf struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	Broker         string   `yaml:"broker"`
+	BrokerURL      string   `yaml:"brokerURL"`
+	Channel        string   `yaml:"channel"`
+}
+
 func Read() {
 	workDir, _ := os.Getwd()
 	viper.SetConfigFile(filepath.Join(workDir, "config.yml"))
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatal(err)
 	}
-	if err := viper.Sub("app").Unmarshal(App); err != nil {
+	app := viper.Sub("app")
+	app.SetDefault("accessTokenTTL", "15m")
+	app.SetDefault("refreshTokenTTL", "720h")
+	app.SetDefault("groupAdminRole", "admin")
+	app.SetDefault("defaultRole", "user")
+	app.SetDefault("name", "api-app")
+	app.SetDefault("adminRequireMFA", true)
+	app.BindEnv("jwtSecret", "APP_JWT_SECRET")
+	if err := app.Unmarshal(App); err != nil {
 		log.Fatal(err)
 	}
 	if err := viper.Sub("database").Unmarshal(Database); err != nil {
 		log.Fatal(err)
 	}
+	mail := viper.Sub("mail")
+	if mail == nil {
+		mail = viper.New()
+	}
+	mail.SetDefault("driver", "log")
+	mail.SetDefault("dir", "log/mail")
+	mail.SetDefault("from", "noreply@localhost")
+	mail.BindEnv("password", "MAIL_PASSWORD")
+	if err := mail.Unmarshal(Mail); err != nil {
+		log.Fatal(err)
+	}
+	if jwt := viper.Sub("jwt"); jwt != nil {
+		if err := jwt.Unmarshal(JWT); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if oauth := viper.Sub("oauth"); oauth != nil {
+		if err := oauth.Unmarshal(OAuth); err != nil {
+			log.Fatal(err)
+		}
+	}
+	websocket := viper.Sub("websocket")
+	if websocket == nil {
+		websocket = viper.New()
+	}
+	websocket.SetDefault("broker", "memory")
+	websocket.SetDefault("channel", "ws")
+	if err := websocket.Unmarshal(Websocket); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,70 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// event types emitted by post and category operations
+const (
+	PostCreated          = "post.created"
+	PostUpdated          = "post.updated"
+	CategoryCreated      = "category.created"
+	CategoryUpdated      = "category.updated"
+	CategoryMoved        = "category.moved"
+	CategoryDeleted      = "category.deleted"
+	CategoryPostsAdded   = "category.posts-added"
+	CategoryPostsRemoved = "category.posts-removed"
+	CategoryPostsMoved   = "category.posts-moved"
+)
+
+// Event is a change which already happened, Topics tell who may be interested in it,
+// e.g. category:1 for changes inside the tree below category 1
+type Event struct {
+	Type       string      `json:"type"`
+	Topics     []string    `json:"topics"`
+	Payload    interface{} `json:"payload"`
+	OccurredAt time.Time   `json:"occurredAt"`
+}
+
+type Handler func(e Event)
+
+// Bus dispatches events to its handlers synchronously in the publishing goroutine,
+// handlers must not block and hand slow work off to their own goroutines
+type Bus struct {
+	locker   sync.RWMutex
+	handlers []Handler
+}
+
+func New() *Bus {
+	return &Bus{handlers: make([]Handler, 0)}
+}
+
+func (b *Bus) Subscribe(h Handler) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+func (b *Bus) Publish(e Event) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+	b.locker.RLock()
+	handlers := b.handlers
+	b.locker.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// Default is the process wide bus
+var Default = New()
+
+func Subscribe(h Handler) {
+	Default.Subscribe(h)
+}
+
+func Publish(e Event) {
+	Default.Publish(e)
+}
@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"time"
+)
+
+// Mailer delivers a plain text mail to a single recipient
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Default is the mailer used by the application, set it during startup
+var Default Mailer = NewLog("log/mail", "noreply@localhost")
+
+// Send deliver through Default
+func Send(to string, subject string, body string) error {
+	return Default.Send(to, subject, body)
+}
+
+func buildMessage(from string, to string, subject string, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(body)
+	return buf.Bytes()
+}
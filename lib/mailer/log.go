@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"app/lib/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var _ Mailer = (*LogMailer)(nil)
+
+// LogMailer writes every mail into Dir as an .eml file instead of sending it,
+// meant for local development and tests
+type LogMailer struct {
+	Dir  string
+	From string
+}
+
+func NewLog(dir string, from string) *LogMailer {
+	return &LogMailer{Dir: dir, From: from}
+}
+
+func (m *LogMailer) Send(to string, subject string, body string) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102150405.000000"), strings.ReplaceAll(to, "@", "_at_"))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, buildMessage(m.From, to, subject, body), 0644); err != nil {
+		return err
+	}
+	if logger.Logger != nil {
+		logger.Logger.Info("mail", zap.String("to", to), zap.String("subject", subject), zap.String("file", path))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+var _ Mailer = (*SMTPMailer)(nil)
+
+// SMTPMailer sends mails through an SMTP server with PLAIN auth,
+// auth is skipped when Username is empty
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTP(host string, port int, username string, password string, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host: host, Port: port, Username: username, Password: password, From: from,
+	}
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.From, []string{to}, buildMessage(m.From, to, subject, body))
+}
@@ -2,15 +2,22 @@ package nestedset
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
 )
 
+// maxRetries is how many times a write that lost a deadlock is tried again
+const maxRetries = 3
+
 // MoveDirection means where the node is going to be located
 type MoveDirection int
 
@@ -35,6 +42,9 @@ type nestedItem struct {
 	ChildrenCount int
 	TableName     string
 	DbNames       map[string]string
+	ScopeNames    []string
+	ScopeValues   []interface{}
+	Model         interface{}
 }
 
 // parseNode parse a gorm struct into an internal nested item struct
@@ -51,6 +61,7 @@ func parseNode(db *gorm.DB, source interface{}) (tx *gorm.DB, item nestedItem, e
 	item = nestedItem{TableName: scm.Table, DbNames: map[string]string{}}
 	sourceValue := reflect.Indirect(reflect.ValueOf(source))
 	sourceType := sourceValue.Type()
+	item.Model = reflect.New(sourceType).Interface()
 	for i := 0; i < sourceType.NumField(); i++ {
 		t := sourceType.Field(i)
 		v := sourceValue.Field(i)
@@ -86,6 +97,8 @@ func parseNode(db *gorm.DB, source interface{}) (tx *gorm.DB, item nestedItem, e
 		case "scope":
 			rawVal, _ := schemaField.ValueOf(sourceValue)
 			tx = tx.Where(dbName+" = ?", rawVal)
+			item.ScopeNames = append(item.ScopeNames, dbName)
+			item.ScopeValues = append(item.ScopeValues, rawVal)
 			break
 		}
 	}
@@ -106,11 +119,14 @@ func Create(db *gorm.DB, source, parent interface{}) error {
 	setToDepth, setToLft, setToRgt := 0, 1, 2
 	dbNames := target.DbNames
 
-	return tx.Transaction(func(tx *gorm.DB) (err error) {
+	return Transaction(tx, func(tx *gorm.DB) (err error) {
+		if err = lockScope(tx, target); err != nil {
+			return err
+		}
 		// create node in root level when parent is nil
 		if parent == nil {
 			lastNode := make(map[string]interface{})
-			rst := tx.Select(dbNames["rgt"]).Order(formatSQL(":rgt DESC", target)).Take(&lastNode)
+			rst := Locking(tx).Select(dbNames["rgt"]).Order(formatSQL(":rgt DESC", target)).Take(&lastNode)
 			if rst.Error == nil {
 				setToLft = int(lastNode[dbNames["rgt"]].(int64) + 1)
 				setToRgt = setToLft + 1
@@ -120,6 +136,9 @@ func Create(db *gorm.DB, source, parent interface{}) error {
 			if err != nil {
 				return err
 			}
+			if targetParent, err = refresh(tx, targetParent); err != nil {
+				return err
+			}
 
 			setToLft = targetParent.Rgt
 			setToRgt = targetParent.Rgt + 1
@@ -194,7 +213,13 @@ func Delete(db *gorm.DB, source interface{}) error {
 		}
 	}
 
-	return tx.Transaction(func(tx *gorm.DB) (err error) {
+	return Transaction(tx, func(tx *gorm.DB) (err error) {
+		if err = lockScope(tx, target); err != nil {
+			return err
+		}
+		if target, err = refresh(tx, target); err != nil {
+			return err
+		}
 		err = tx.Where(formatSQL(":lft >= ? AND :rgt <= ?", target), target.Lft, target.Rgt).
 			Delete(source).Error
 		if err != nil {
@@ -213,7 +238,7 @@ func Delete(db *gorm.DB, source interface{}) error {
 			}
 		}
 
-		return nil
+		return syncChildrenCount(tx, target, target.ParentID, sql.NullInt64{})
 	})
 }
 
@@ -230,28 +255,109 @@ func MoveTo(db *gorm.DB, node, to interface{}, direction MoveDirection) error {
 		return err
 	}
 
-	err = moveIsValid(targetNode, toNode)
-	if err != nil {
-		return err
-	}
+	return Transaction(tx, func(tx *gorm.DB) (err error) {
+		if err = lockScope(tx, targetNode); err != nil {
+			return err
+		}
+		if targetNode, err = refresh(tx, targetNode); err != nil {
+			return err
+		}
+		if toNode, err = refresh(tx, toNode); err != nil {
+			return err
+		}
+
+		err = moveIsValid(targetNode, toNode)
+		if err != nil {
+			return err
+		}
 
-	var right, depthChange int
-	var newParentID sql.NullInt64
-	if direction == MoveDirectionLeft || direction == MoveDirectionRight {
-		newParentID = toNode.ParentID
-		depthChange = toNode.Depth - targetNode.Depth
-		if direction == MoveDirectionLeft {
-			right = toNode.Lft - 1
+		var right, depthChange int
+		var newParentID sql.NullInt64
+		if direction == MoveDirectionLeft || direction == MoveDirectionRight {
+			newParentID = toNode.ParentID
+			depthChange = toNode.Depth - targetNode.Depth
+			if direction == MoveDirectionLeft {
+				right = toNode.Lft - 1
+			} else {
+				right = toNode.Rgt
+			}
 		} else {
-			right = toNode.Rgt
+			newParentID = sql.NullInt64{Int64: toNode.ID, Valid: true}
+			depthChange = toNode.Depth + 1 - targetNode.Depth
+			right = toNode.Lft
 		}
-	} else {
-		newParentID = sql.NullInt64{Int64: toNode.ID, Valid: true}
-		depthChange = toNode.Depth + 1 - targetNode.Depth
-		right = toNode.Lft
+
+		return moveToRightOfPosition(tx, targetNode, right, depthChange, newParentID)
+	})
+}
+
+// Transaction run fn in a transaction of db, retrying it up to maxRetries times when MySQL
+// reports a deadlock or a lock wait timeout, other errors and databases are never retried.
+// When db already is a transaction fn joins it and is not retried, as a deadlock aborts the
+// whole transaction only its outermost Transaction may run it again, so fn must be safe to rerun
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	if committer, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok && committer != nil {
+		return db.Transaction(fn)
+	}
+	for attempt := 1; ; attempt++ {
+		err = db.Transaction(fn)
+		if attempt > maxRetries || !isLockConflict(err) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt*10) * time.Millisecond)
 	}
+}
+
+func isLockConflict(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	// 1213 is a deadlock, 1205 a lock wait timeout
+	return errors.As(err, &mysqlErr) && (mysqlErr.Number == 1213 || mysqlErr.Number == 1205)
+}
 
-	return moveToRightOfPosition(tx, targetNode, right, depthChange, newParentID)
+// Locking turn reads of tx into SELECT ... FOR UPDATE, sqlite has no row locks and locks the
+// database for writes instead
+func Locking(tx *gorm.DB) *gorm.DB {
+	if tx.Dialector.Name() == "sqlite" {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// LockScope lock the tree node belongs to until the transaction db ends, see lockScope.
+// Under REPEATABLE READ a transaction reads the snapshot taken by its first plain read, so
+// callers reading the tree before a write should lock first
+// ```nestedset.LockScope(tx, &Category{OwnerID: "..."})```
+func LockScope(db *gorm.DB, node interface{}) error {
+	tx, item, err := parseNode(db, node)
+	if err != nil {
+		return err
+	}
+	return lockScope(tx, item)
+}
+
+// lockScope lock the roots of the tree tx is scoped to until tx ends, so writers of a tree
+// queue up while writers of other trees go on
+func lockScope(tx *gorm.DB, item nestedItem) error {
+	ids := make([]int64, 0)
+	return Locking(tx).Where(formatSQL(":parent_id IS NULL", item)).
+		Order(formatSQL(":id ASC", item)).Pluck(item.DbNames["id"], &ids).Error
+}
+
+// refresh read lft, rgt, depth and parent of item again, callers may pass nodes that went
+// stale before the scope was locked. Like every read of a write it is a locking read, which sees
+// the latest rows where a plain one would see the snapshot of a transaction that read earlier
+func refresh(tx *gorm.DB, item nestedItem) (nestedItem, error) {
+	var row treeRow
+	rst := Locking(tx).Select(formatSQL(":parent_id AS parent_id, :lft AS lft, :rgt AS rgt, :depth AS depth, :children_count AS children_count", item)).
+		Where(formatSQL(":id = ?", item), item.ID).Scan(&row)
+	if rst.Error != nil {
+		return item, rst.Error
+	}
+	if rst.RowsAffected == 0 {
+		return item, gorm.ErrRecordNotFound
+	}
+	item.ParentID, item.Lft, item.Rgt, item.Depth, item.ChildrenCount = row.ParentID, row.Lft, row.Rgt, row.Depth, row.ChildrenCount
+	return item, nil
 }
 
 func moveIsValid(node, to nestedItem) error {
@@ -271,7 +377,7 @@ func moveToRightOfPosition(tx *gorm.DB, targetNode nestedItem, position, depthCh
 		targetWidth := targetRight - targetLeft + 1
 
 		targetIds := []int64{}
-		err = tx.Where(formatSQL(":lft >= ? AND :rgt <= ?", targetNode), targetLeft, targetRight).Pluck("id", &targetIds).Error
+		err = Locking(tx).Where(formatSQL(":lft >= ? AND :rgt <= ?", targetNode), targetLeft, targetRight).Pluck("id", &targetIds).Error
 		if err != nil {
 			return
 		}
@@ -310,7 +416,7 @@ func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParen
 	var oldParentCount, newParentCount int64
 
 	if oldParentID.Valid {
-		err = tx.Where(formatSQL(":parent_id = ?", targetNode), oldParentID).Count(&oldParentCount).Error
+		oldParentCount, err = countChildren(tx, targetNode, oldParentID.Int64)
 		if err != nil {
 			return
 		}
@@ -321,7 +427,7 @@ func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParen
 	}
 
 	if newParentID.Valid {
-		err = tx.Where(formatSQL(":parent_id = ?", targetNode), newParentID).Count(&newParentCount).Error
+		newParentCount, err = countChildren(tx, targetNode, newParentID.Int64)
 		if err != nil {
 			return
 		}
@@ -334,6 +440,13 @@ func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParen
 	return nil
 }
 
+// countChildren count the children of parentID through the model, so soft deleted ones are left out
+func countChildren(tx *gorm.DB, item nestedItem, parentID int64) (count int64, err error) {
+	err = Locking(tx.Session(&gorm.Session{NewDB: true})).Model(item.Model).
+		Where(formatSQL(":parent_id = ?", item), parentID).Count(&count).Error
+	return
+}
+
 func moveTarget(tx *gorm.DB, targetNode nestedItem, targetID int64, targetIds []int64, step, depthChange int, newParentID sql.NullInt64) (err error) {
 	dbNames := targetNode.DbNames
 
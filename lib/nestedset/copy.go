@@ -0,0 +1,126 @@
+package nestedset
+
+import (
+	"database/sql"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// CopyTo copy node and all its descendants into parent as its last child, parent may belong
+// to another scope. Every copy is handed to prepare right before it is created, e.g. to rename it,
+// and the ids of the copies keyed by the ids of their originals are returned
+// ```nestedset.CopyTo(db, &node, &parent, nil)```
+func CopyTo(db *gorm.DB, node, parent interface{}, prepare func(tx *gorm.DB, copied interface{}) error) (map[int64]int64, error) {
+	ids := make(map[int64]int64)
+	_, source, err := parseNode(db, node)
+	if err != nil {
+		return ids, err
+	}
+	tx, target, err := parseNode(db, parent)
+	if err != nil {
+		return ids, err
+	}
+	dbNames := target.DbNames
+
+	err = Transaction(tx, func(tx *gorm.DB) (err error) {
+		if err = lockScope(tx, target); err != nil {
+			return err
+		}
+		if target, err = refresh(tx, target); err != nil {
+			return err
+		}
+		// tx is bound to the tree of parent, the subtree is read from its own tree
+		sourceTx, _, err := parseNode(tx.Session(&gorm.Session{NewDB: true}), node)
+		if err != nil {
+			return err
+		}
+		sourceTx = sourceTx.Session(&gorm.Session{})
+		if source, err = refresh(sourceTx, source); err != nil {
+			return err
+		}
+
+		// the subtree is read before the gap is opened, so positions below are relative to the tree as it was
+		rows := reflect.New(reflect.SliceOf(reflect.Indirect(reflect.ValueOf(node)).Type()))
+		err = Locking(sourceTx).Where(formatSQL(":lft >= ? AND :rgt <= ?", source), source.Lft, source.Rgt).
+			Order(formatSQL(":lft ASC", source)).Find(rows.Interface()).Error
+		if err != nil {
+			return err
+		}
+
+		// UPDATE tree SET rgt = rgt + width WHERE rgt >= parent_rgt;
+		// UPDATE tree SET lft = lft + width WHERE lft > parent_rgt;
+		width := source.Rgt - source.Lft + 1
+		err = tx.Where(formatSQL(":rgt >= ?", target), target.Rgt).
+			UpdateColumn(dbNames["rgt"], gorm.Expr(formatSQL(":rgt + ?", target), width)).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where(formatSQL(":lft > ?", target), target.Rgt).
+			UpdateColumn(dbNames["lft"], gorm.Expr(formatSQL(":lft + ?", target), width)).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where(formatSQL(":id = ?", target), target.ID).
+			UpdateColumn(dbNames["children_count"], gorm.Expr(formatSQL(":children_count + 1", target))).Error
+		if err != nil {
+			return err
+		}
+
+		step := target.Rgt - source.Lft
+		depthChange := target.Depth + 1 - source.Depth
+		create := tx.Session(&gorm.Session{NewDB: true})
+		for i := 0; i < rows.Elem().Len(); i++ {
+			row := rows.Elem().Index(i)
+			id := taggedField(row, "id").Int()
+			parentID := sql.NullInt64{Int64: target.ID, Valid: true}
+			if i > 0 {
+				parentID.Int64 = ids[taggedField(row, "parent_id").Interface().(sql.NullInt64).Int64]
+			}
+			taggedField(row, "id").SetInt(0)
+			taggedField(row, "parent_id").Set(reflect.ValueOf(parentID))
+			for _, tag := range []string{"lft", "rgt"} {
+				f := taggedField(row, tag)
+				f.SetInt(f.Int() + int64(step))
+			}
+			f := taggedField(row, "depth")
+			f.SetInt(f.Int() + int64(depthChange))
+			setScope(row, target.ScopeValues)
+
+			if prepare != nil {
+				if err = prepare(tx, row.Addr().Interface()); err != nil {
+					return err
+				}
+			}
+			if err = create.Create(row.Addr().Interface()).Error; err != nil {
+				return err
+			}
+			ids[id] = taggedField(row, "id").Int()
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// taggedField find the field of struct v tagged `nestedset:"<tag>"`
+func taggedField(v reflect.Value, tag string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("nestedset") == tag {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// setScope assign values to the fields of struct v tagged `nestedset:"scope"` in their order
+func setScope(v reflect.Value, values []interface{}) {
+	t := v.Type()
+	n := 0
+	for i := 0; i < t.NumField() && n < len(values); i++ {
+		if t.Field(i).Tag.Get("nestedset") == "scope" {
+			v.Field(i).Set(reflect.ValueOf(values[n]).Convert(t.Field(i).Type))
+			n++
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package nestedset
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// scopedQuery limit db to the tree node belongs to, results are read into the
+// model of the destination so soft deleted nodes are left out as gorm does
+func scopedQuery(db *gorm.DB, node interface{}) (*gorm.DB, nestedItem, error) {
+	_, item, err := parseNode(db, node)
+	if err != nil {
+		return db, item, err
+	}
+	tx := db
+	for i, name := range item.ScopeNames {
+		tx = tx.Where(name+" = ?", item.ScopeValues[i])
+	}
+	return tx, item, nil
+}
+
+// Ancestors find ancestors of node from its root down to its parent
+// ```nestedset.Ancestors(db, &node, &rows)```
+func Ancestors(db *gorm.DB, node, out interface{}) error {
+	tx, item, err := scopedQuery(db, node)
+	if err != nil {
+		return err
+	}
+	return tx.Where(formatSQL(":lft < ? AND :rgt > ?", item), item.Lft, item.Rgt).
+		Order(formatSQL(":lft ASC", item)).Find(out).Error
+}
+
+// Path find ancestors of node followed by node itself, e.g. for a breadcrumb
+// ```nestedset.Path(db, &node, &rows)```
+func Path(db *gorm.DB, node, out interface{}) error {
+	tx, item, err := scopedQuery(db, node)
+	if err != nil {
+		return err
+	}
+	return tx.Where(formatSQL(":lft <= ? AND :rgt >= ?", item), item.Lft, item.Rgt).
+		Order(formatSQL(":lft ASC", item)).Find(out).Error
+}
+
+// Descendants find descendants of node ordered by lft, at most depth levels below node
+// when depth is positive, pass the result to Nest for a nested tree
+// ```nestedset.Descendants(db, &node, 2, &rows)``` will find children and grandchildren
+func Descendants(db *gorm.DB, node interface{}, depth int, out interface{}) error {
+	tx, item, err := scopedQuery(db, node)
+	if err != nil {
+		return err
+	}
+	tx = tx.Where(formatSQL(":lft > ? AND :rgt < ?", item), item.Lft, item.Rgt)
+	if depth > 0 {
+		tx = tx.Where(formatSQL(":depth <= ?", item), item.Depth+depth)
+	}
+	return tx.Order(formatSQL(":lft ASC", item)).Find(out).Error
+}
+
+// Siblings find the other nodes sharing the parent of node ordered by lft,
+// roots are siblings of each other within a scope
+func Siblings(db *gorm.DB, node, out interface{}) error {
+	tx, item, err := scopedQuery(db, node)
+	if err != nil {
+		return err
+	}
+	if item.ParentID.Valid {
+		tx = tx.Where(formatSQL(":parent_id = ?", item), item.ParentID.Int64)
+	} else {
+		tx = tx.Where(formatSQL(":parent_id IS NULL", item))
+	}
+	return tx.Where(formatSQL(":id <> ?", item), item.ID).
+		Order(formatSQL(":lft ASC", item)).Find(out).Error
+}
+
+// Leaves find descendants of node without children ordered by lft
+func Leaves(db *gorm.DB, node, out interface{}) error {
+	tx, item, err := scopedQuery(db, node)
+	if err != nil {
+		return err
+	}
+	return tx.Where(formatSQL(":lft > ? AND :rgt < ? AND :rgt = :lft + 1", item), item.Lft, item.Rgt).
+		Order(formatSQL(":lft ASC", item)).Find(out).Error
+}
+
+// IsDescendantOf tell whether node is below ancestor in the same tree, lft and rgt of both must be current
+func IsDescendantOf(db *gorm.DB, node, ancestor interface{}) (bool, error) {
+	_, item, err := parseNode(db, node)
+	if err != nil {
+		return false, err
+	}
+	_, other, err := parseNode(db, ancestor)
+	if err != nil {
+		return false, err
+	}
+	if !reflect.DeepEqual(item.ScopeValues, other.ScopeValues) {
+		return false, nil
+	}
+	return item.Lft > other.Lft && item.Rgt < other.Rgt, nil
+}
+
+// Nest turn rows ordered by lft, e.g. found by Descendants, into a forest by filling the field
+// tagged `nestedset:"children"` of every row with the rows below it
+// ```nestedset.Nest(&rows)``` leaves only the topmost rows in rows
+func Nest(rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Invalid rows, must be a pointer to a slice, %T", rows)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	lft, rgt, children := -1, -1, -1
+	for i := 0; i < elemType.NumField(); i++ {
+		switch elemType.Field(i).Tag.Get("nestedset") {
+		case "lft":
+			lft = i
+		case "rgt":
+			rgt = i
+		case "children":
+			if elemType.Field(i).Type == slice.Type() {
+				children = i
+			}
+		}
+	}
+	if lft < 0 || rgt < 0 || children < 0 {
+		return fmt.Errorf("Invalid rows, %v needs lft, rgt and children tags", elemType)
+	}
+	i := 0
+	slice.Set(nest(slice, &i, -1, lft, rgt, children))
+	return nil
+}
+
+// nest collect rows from i on while they are within bound, the rgt of their parent
+func nest(rows reflect.Value, i *int, bound, lft, rgt, children int) reflect.Value {
+	out := reflect.MakeSlice(rows.Type(), 0, 0)
+	for *i < rows.Len() {
+		row := rows.Index(*i)
+		if bound >= 0 && int(row.Field(lft).Int()) > bound {
+			break
+		}
+		*i++
+		item := reflect.New(row.Type()).Elem()
+		item.Set(row)
+		item.Field(children).Set(nest(rows, i, int(row.Field(rgt).Int()), lft, rgt, children))
+		out = reflect.Append(out, item)
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package nestedset
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type node struct {
+	ID            int64         `gorm:"primaryKey;autoIncrement" nestedset:"id"`
+	Tree          string        `nestedset:"scope"`
+	Name          string        `gorm:"size:100"`
+	ParentID      sql.NullInt64 `nestedset:"parent_id"`
+	Rgt           int           `nestedset:"rgt"`
+	Lft           int           `nestedset:"lft"`
+	Depth         int           `nestedset:"depth"`
+	ChildrenCount int           `nestedset:"children_count"`
+}
+
+// setupTree create in tree x
+//
+//	root
+//	├── a
+//	│   ├── a1
+//	│   │   └── a11
+//	│   └── a2
+//	└── b
+//	other
+//
+// and a root of its own in tree y, nodes are returned by name as stored
+func setupTree(t *testing.T) (*gorm.DB, map[string]node) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&node{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if d, err := db.DB(); err == nil {
+			_ = d.Close()
+		}
+	})
+	created := make(map[string]node)
+	for _, n := range []struct{ tree, name, parent string }{
+		{"x", "root", ""}, {"x", "a", "root"}, {"x", "a1", "a"}, {"x", "a11", "a1"},
+		{"x", "a2", "a"}, {"x", "b", "root"}, {"x", "other", ""}, {"y", "foreign", ""},
+	} {
+		m := node{Tree: n.tree, Name: n.name}
+		var err error
+		if n.parent == "" {
+			err = Create(db, &m, nil)
+		} else {
+			var parent node
+			if err := db.First(&parent, created[n.parent].ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			m.ParentID = sql.NullInt64{Int64: parent.ID, Valid: true}
+			err = Create(db, &m, &parent)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		created[n.name] = m
+	}
+	for name, m := range created {
+		if err := db.First(&m, m.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		created[name] = m
+	}
+	return db, created
+}
+
+func names(rows []node) []string {
+	out := make([]string, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r.Name)
+	}
+	return out
+}
+
+func TestQueries(t *testing.T) {
+	db, nodes := setupTree(t)
+	cases := []struct {
+		name  string
+		query func(out *[]node) error
+		want  []string
+	}{
+		{"descendants", func(out *[]node) error { return Descendants(db, nodes["root"], 0, out) },
+			[]string{"a", "a1", "a11", "a2", "b"}},
+		{"descendants one level down", func(out *[]node) error { return Descendants(db, nodes["root"], 1, out) },
+			[]string{"a", "b"}},
+		{"descendants two levels down", func(out *[]node) error { return Descendants(db, nodes["root"], 2, out) },
+			[]string{"a", "a1", "a2", "b"}},
+		{"descendants of a leaf", func(out *[]node) error { return Descendants(db, nodes["a11"], 0, out) },
+			[]string{}},
+		{"siblings", func(out *[]node) error { return Siblings(db, nodes["a1"], out) },
+			[]string{"a2"}},
+		{"siblings of a root stay in its tree", func(out *[]node) error { return Siblings(db, nodes["root"], out) },
+			[]string{"other"}},
+		{"leaves", func(out *[]node) error { return Leaves(db, nodes["root"], out) },
+			[]string{"a11", "a2", "b"}},
+		{"leaves below a leaf", func(out *[]node) error { return Leaves(db, nodes["b"], out) },
+			[]string{}},
+		{"ancestors", func(out *[]node) error { return Ancestors(db, nodes["a11"], out) },
+			[]string{"root", "a", "a1"}},
+		{"path", func(out *[]node) error { return Path(db, nodes["a11"], out) },
+			[]string{"root", "a", "a1", "a11"}},
+	}
+	for _, c := range cases {
+		rows := make([]node, 0)
+		if err := c.query(&rows); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := names(rows); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: found %v, expected %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package nestedset
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of issues reported by Verify
+const (
+	IssueInvalidRange   = "invalid_range"
+	IssueGap            = "gap"
+	IssueOverlap        = "overlap"
+	IssueOutsideParent  = "outside_parent"
+	IssueOrphan         = "orphan"
+	IssueDepth          = "depth"
+	IssueChildrenCount  = "children_count"
+	IssueUnreachable    = "unreachable"
+	IssueDuplicateValue = "duplicate_value"
+)
+
+// Issue is an inconsistency of a single node, ID is 0 when it concerns a whole scope
+type Issue struct {
+	Kind    string `json:"kind"`
+	Scope   string `json:"scope,omitempty"`
+	ID      int64  `json:"id"`
+	Message string `json:"message"`
+}
+
+// Report is the outcome of Verify
+type Report struct {
+	Nodes  int     `json:"nodes"`
+	Issues []Issue `json:"issues"`
+}
+
+func (r Report) Valid() bool {
+	return len(r.Issues) == 0
+}
+
+type treeRow struct {
+	ID            int64
+	ParentID      sql.NullInt64
+	Lft           int
+	Rgt           int
+	Depth         int
+	ChildrenCount int
+	Scope         string
+}
+
+// loadTree read every live node of model grouped by scope and ordered by lft,
+// soft deleted nodes are left out as gorm does for model
+func loadTree(db *gorm.DB, model interface{}) (nestedItem, map[string][]treeRow, error) {
+	_, item, err := parseNode(db, model)
+	if err != nil {
+		return item, nil, err
+	}
+	scope := "''"
+	if len(item.ScopeNames) > 0 {
+		scope = "CONCAT_WS(',', " + strings.Join(item.ScopeNames, ", ") + ")"
+		if db.Dialector.Name() == "sqlite" {
+			scope = strings.Join(item.ScopeNames, " || ',' || ")
+		}
+	}
+	rows := make([]treeRow, 0)
+	err = db.Model(model).Select(formatSQL(
+		":id AS id, :parent_id AS parent_id, :lft AS lft, :rgt AS rgt, :depth AS depth, :children_count AS children_count, "+scope+" AS scope",
+		item)).Order(formatSQL(":lft ASC", item)).Scan(&rows).Error
+	if err != nil {
+		return item, nil, err
+	}
+	trees := make(map[string][]treeRow)
+	for _, row := range rows {
+		trees[row.Scope] = append(trees[row.Scope], row)
+	}
+	return item, trees, nil
+}
+
+// Verify check the tree of model for gaps and duplicates in lft/rgt, nodes overlapping
+// or lying outside their parent, wrong depths and wrong children counts. Every scope
+// is checked on its own, model is a pointer to a tagged struct, e.g. &Category{}
+func Verify(db *gorm.DB, model interface{}) (Report, error) {
+	report := Report{Issues: make([]Issue, 0)}
+	_, trees, err := loadTree(db, model)
+	if err != nil {
+		return report, err
+	}
+	scopes := make([]string, 0, len(trees))
+	for scope := range trees {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		rows := trees[scope]
+		report.Nodes += len(rows)
+		report.Issues = append(report.Issues, verifyTree(scope, rows)...)
+	}
+	return report, nil
+}
+
+func verifyTree(scope string, rows []treeRow) []Issue {
+	issues := make([]Issue, 0)
+	report := func(kind string, id int64, format string, args ...interface{}) {
+		issues = append(issues, Issue{Kind: kind, Scope: scope, ID: id, Message: fmt.Sprintf(format, args...)})
+	}
+
+	nodes := make(map[int64]treeRow, len(rows))
+	children := make(map[int64]int)
+	values := make(map[int]int64)
+	for _, row := range rows {
+		nodes[row.ID] = row
+		if row.Lft >= row.Rgt {
+			report(IssueInvalidRange, row.ID, "lft %d is not less than rgt %d", row.Lft, row.Rgt)
+		}
+		for _, v := range []int{row.Lft, row.Rgt} {
+			if other, ok := values[v]; ok {
+				report(IssueDuplicateValue, row.ID, "value %d is also used by node %d", v, other)
+			}
+			values[v] = row.ID
+		}
+	}
+	for v := 1; v <= 2*len(rows); v++ {
+		if _, ok := values[v]; !ok {
+			report(IssueGap, 0, "value %d is not used by any node", v)
+		}
+	}
+
+	// rows are ordered by lft, a valid tree nests every interval inside the open ones
+	stack := make([]treeRow, 0)
+	for _, row := range rows {
+		for len(stack) > 0 && stack[len(stack)-1].Rgt < row.Lft {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 && row.Rgt > stack[len(stack)-1].Rgt {
+			report(IssueOverlap, row.ID, "[%d, %d] overlaps node %d [%d, %d]",
+				row.Lft, row.Rgt, stack[len(stack)-1].ID, stack[len(stack)-1].Lft, stack[len(stack)-1].Rgt)
+		}
+		stack = append(stack, row)
+	}
+
+	for _, row := range rows {
+		if !row.ParentID.Valid {
+			if row.Depth != 0 {
+				report(IssueDepth, row.ID, "root has depth %d, expected 0", row.Depth)
+			}
+			continue
+		}
+		parent, ok := nodes[row.ParentID.Int64]
+		if !ok {
+			report(IssueOrphan, row.ID, "parent %d does not exist", row.ParentID.Int64)
+			continue
+		}
+		children[parent.ID]++
+		if row.Lft <= parent.Lft || row.Rgt >= parent.Rgt {
+			report(IssueOutsideParent, row.ID, "[%d, %d] is not inside parent %d [%d, %d]",
+				row.Lft, row.Rgt, parent.ID, parent.Lft, parent.Rgt)
+		}
+		if row.Depth != parent.Depth+1 {
+			report(IssueDepth, row.ID, "depth is %d, expected %d", row.Depth, parent.Depth+1)
+		}
+	}
+	for _, row := range rows {
+		if row.ChildrenCount != children[row.ID] {
+			report(IssueChildrenCount, row.ID, "children count is %d, expected %d", row.ChildrenCount, children[row.ID])
+		}
+	}
+
+	// walking parent_id down from roots and orphans, which Rebuild adopts, reaches every
+	// node but those hanging in a parent_id cycle
+	below := make(map[int64][]int64)
+	queue := make([]int64, 0)
+	for _, row := range rows {
+		if _, ok := nodes[row.ParentID.Int64]; row.ParentID.Valid && ok {
+			below[row.ParentID.Int64] = append(below[row.ParentID.Int64], row.ID)
+		} else {
+			queue = append(queue, row.ID)
+		}
+	}
+	reached := make(map[int64]bool, len(rows))
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		reached[id] = true
+		queue = append(queue, below[id]...)
+	}
+	for _, row := range rows {
+		if !reached[row.ID] {
+			report(IssueUnreachable, row.ID, "not reachable from a root, parent_id has a cycle")
+		}
+	}
+	return issues
+}
+
+// Rebuild recompute lft, rgt, depth and children count of every live node of model from parent_id,
+// siblings keep their current order. Nodes whose parent is gone move under the first root of their
+// scope, or become roots when the scope has none, a parent_id cycle fails the rebuild of its scope.
+// Every scope is rebuilt in a transaction of its own locking only that tree, so writers of other
+// trees go on, and scopes rebuilt before a failing one stay rebuilt. Returns how many nodes were updated.
+func Rebuild(db *gorm.DB, model interface{}) (int, error) {
+	_, item, err := parseNode(db, model)
+	if err != nil {
+		return 0, err
+	}
+	scopes := []map[string]interface{}{{}}
+	if len(item.ScopeNames) > 0 {
+		names := make([]interface{}, 0, len(item.ScopeNames))
+		for _, name := range item.ScopeNames {
+			names = append(names, name)
+		}
+		scopes = make([]map[string]interface{}, 0)
+		if err := db.Model(model).Distinct(names...).Find(&scopes).Error; err != nil {
+			return 0, err
+		}
+	}
+	updated := 0
+	for _, scope := range scopes {
+		n, err := rebuildScope(db, model, item, scope)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+	}
+	return updated, nil
+}
+
+// rebuildScope rebuild the tree of model whose scope columns hold the values of scope
+func rebuildScope(db *gorm.DB, model interface{}, item nestedItem, scope map[string]interface{}) (int, error) {
+	updated := 0
+	err := Transaction(db, func(tx *gorm.DB) error {
+		updated = 0
+		// a new session, so that queries built on it don't add their conditions to each other
+		scoped := func(tx *gorm.DB) *gorm.DB {
+			for _, name := range item.ScopeNames {
+				tx = tx.Where(name+" = ?", scope[name])
+			}
+			return tx.Session(&gorm.Session{})
+		}
+		if err := lockScope(scoped(tx.Table(item.TableName)), item); err != nil {
+			return err
+		}
+		_, trees, err := loadTree(scoped(tx), model)
+		if err != nil {
+			return err
+		}
+		for key, rows := range trees {
+			computed, err := rebuildTree(rows)
+			if err != nil {
+				return fmt.Errorf("%v in scope %q", err, key)
+			}
+			for _, row := range computed {
+				current := rows[row.index]
+				if current.Lft == row.Lft && current.Rgt == row.Rgt && current.Depth == row.Depth &&
+					current.ChildrenCount == row.ChildrenCount && current.ParentID == row.ParentID {
+					continue
+				}
+				err := tx.Table(item.TableName).Where(formatSQL(":id = ?", item), row.ID).UpdateColumns(map[string]interface{}{
+					item.DbNames["parent_id"]:      row.ParentID,
+					item.DbNames["lft"]:            row.Lft,
+					item.DbNames["rgt"]:            row.Rgt,
+					item.DbNames["depth"]:          row.Depth,
+					item.DbNames["children_count"]: row.ChildrenCount,
+				}).Error
+				if err != nil {
+					return err
+				}
+				updated++
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+type rebuiltRow struct {
+	treeRow
+	index int
+}
+
+// rebuildTree number rows by walking them depth first from parent_id, rows are ordered by lft
+func rebuildTree(rows []treeRow) ([]rebuiltRow, error) {
+	exists := make(map[int64]bool, len(rows))
+	// orphans are adopted by the first root, the one a scoped tree is expected to have
+	adopter := sql.NullInt64{}
+	for _, row := range rows {
+		exists[row.ID] = true
+		if !row.ParentID.Valid && !adopter.Valid {
+			adopter = sql.NullInt64{Int64: row.ID, Valid: true}
+		}
+	}
+	parents := make([]sql.NullInt64, len(rows))
+	roots := make([]int, 0)
+	children := make(map[int64][]int)
+	for i, row := range rows {
+		parents[i] = row.ParentID
+		if row.ParentID.Valid && !exists[row.ParentID.Int64] {
+			parents[i] = adopter
+		}
+		if !parents[i].Valid {
+			roots = append(roots, i)
+		} else {
+			children[parents[i].Int64] = append(children[parents[i].Int64], i)
+		}
+	}
+	computed := make([]rebuiltRow, 0, len(rows))
+	counter := 0
+	var walk func(i int, depth int)
+	walk = func(i int, depth int) {
+		row := rebuiltRow{treeRow: rows[i], index: i}
+		row.ParentID = parents[i]
+		counter++
+		row.Lft, row.Depth = counter, depth
+		row.ChildrenCount = len(children[row.ID])
+		at := len(computed)
+		computed = append(computed, row)
+		for _, child := range children[row.ID] {
+			walk(child, depth+1)
+		}
+		counter++
+		computed[at].Rgt = counter
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+	if len(computed) != len(rows) {
+		return computed, fmt.Errorf("%d nodes are not reachable from a root, parent_id has a cycle", len(rows)-len(computed))
+	}
+	return computed, nil
+}
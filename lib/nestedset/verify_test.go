@@ -0,0 +1,133 @@
+package nestedset
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// row build a tree row, parent 0 stands for none
+func row(id int64, parent int64, lft, rgt, depth, children int) treeRow {
+	r := treeRow{ID: id, Lft: lft, Rgt: rgt, Depth: depth, ChildrenCount: children}
+	if parent != 0 {
+		r.ParentID = sql.NullInt64{Int64: parent, Valid: true}
+	}
+	return r
+}
+
+func TestVerifyTree(t *testing.T) {
+	cases := []struct {
+		name  string
+		rows  []treeRow
+		kinds []string
+	}{
+		{"valid", []treeRow{row(1, 0, 1, 6, 0, 2), row(2, 1, 2, 3, 1, 0), row(3, 1, 4, 5, 1, 0)}, nil},
+		{"gap", []treeRow{row(1, 0, 1, 3, 0, 0)}, []string{IssueGap}},
+		{"duplicate value", []treeRow{row(1, 0, 1, 4, 0, 1), row(2, 1, 2, 4, 1, 0)},
+			[]string{IssueDuplicateValue, IssueGap, IssueOutsideParent}},
+		{"overlap", []treeRow{row(1, 0, 1, 3, 0, 0), row(2, 0, 2, 4, 0, 0)}, []string{IssueOverlap}},
+		{"invalid range", []treeRow{row(1, 0, 2, 1, 0, 0)}, []string{IssueInvalidRange}},
+		{"depth", []treeRow{row(1, 0, 1, 4, 0, 1), row(2, 1, 2, 3, 2, 0)}, []string{IssueDepth}},
+		{"children count", []treeRow{row(1, 0, 1, 4, 0, 0), row(2, 1, 2, 3, 1, 0)}, []string{IssueChildrenCount}},
+		{"orphan", []treeRow{row(1, 0, 1, 2, 0, 0), row(2, 9, 3, 4, 1, 0)}, []string{IssueOrphan}},
+		// 2 and 3 are each other's parent, no root leads to them
+		{"cycle", []treeRow{row(1, 0, 1, 6, 0, 0), row(2, 3, 2, 3, 1, 1), row(3, 2, 4, 5, 1, 1)},
+			[]string{IssueDepth, IssueDepth, IssueOutsideParent, IssueOutsideParent, IssueUnreachable, IssueUnreachable}},
+	}
+	for _, c := range cases {
+		kinds := make([]string, 0)
+		for _, issue := range verifyTree("", c.rows) {
+			kinds = append(kinds, issue.Kind)
+		}
+		sort.Strings(kinds)
+		want := append([]string{}, c.kinds...)
+		sort.Strings(want)
+		if !reflect.DeepEqual(kinds, want) {
+			t.Errorf("%s: reported %v, expected %v", c.name, kinds, want)
+		}
+	}
+}
+
+func TestRebuildTree(t *testing.T) {
+	cases := []struct {
+		name string
+		rows []treeRow
+		// want maps id to lft, rgt, depth, parent and children count, parent 0 for roots
+		want map[int64][5]int64
+	}{
+		{"renumbers in lft order", []treeRow{row(1, 0, 1, 20, 3, 0), row(2, 1, 5, 6, 0, 0), row(3, 1, 9, 8, 0, 0)},
+			map[int64][5]int64{1: {1, 6, 0, 0, 2}, 2: {2, 3, 1, 1, 0}, 3: {4, 5, 1, 1, 0}}},
+		{"orphan goes under the first root", []treeRow{row(1, 0, 1, 2, 0, 0), row(2, 0, 3, 4, 0, 0), row(3, 9, 5, 6, 1, 0)},
+			map[int64][5]int64{1: {1, 4, 0, 0, 1}, 3: {2, 3, 1, 1, 0}, 2: {5, 6, 0, 0, 0}}},
+		{"orphan without root becomes one", []treeRow{row(3, 9, 1, 4, 1, 1), row(4, 3, 2, 3, 2, 0)},
+			map[int64][5]int64{3: {1, 4, 0, 0, 1}, 4: {2, 3, 1, 3, 0}}},
+	}
+	for _, c := range cases {
+		computed, err := rebuildTree(c.rows)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := make(map[int64][5]int64, len(computed))
+		for _, r := range computed {
+			got[r.ID] = [5]int64{int64(r.Lft), int64(r.Rgt), int64(r.Depth), r.ParentID.Int64, int64(r.ChildrenCount)}
+			if c.rows[r.index].ID != r.ID {
+				t.Errorf("%s: node %d points at row of node %d", c.name, r.ID, c.rows[r.index].ID)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: rebuilt %v, expected %v", c.name, got, c.want)
+		}
+		if issues := verifyTree("", rebuiltRows(computed)); len(issues) > 0 {
+			t.Errorf("%s: rebuilt tree is invalid, %v", c.name, issues)
+		}
+	}
+}
+
+func TestRebuildTreeCycle(t *testing.T) {
+	if _, err := rebuildTree([]treeRow{row(1, 0, 1, 6, 0, 0), row(2, 3, 2, 3, 1, 1), row(3, 2, 4, 5, 1, 1)}); err == nil {
+		t.Fatal("expected a parent_id cycle to fail the rebuild")
+	}
+}
+
+// rebuiltRows turn computed rows back into rows ordered by lft, as Verify reads them
+func rebuiltRows(computed []rebuiltRow) []treeRow {
+	rows := make([]treeRow, 0, len(computed))
+	for _, r := range computed {
+		rows = append(rows, r.treeRow)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Lft < rows[j].Lft })
+	return rows
+}
+
+func TestRebuildEveryScope(t *testing.T) {
+	db, nodes := setupTree(t)
+	for _, name := range []string{"a1", "foreign"} {
+		if err := db.Model(&node{}).Where("id = ?", nodes[name].ID).UpdateColumn("lft", 100).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := Verify(db, &node{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid() {
+		t.Fatal("expected the broken trees to be reported")
+	}
+
+	updated, err := Rebuild(db, &node{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a1 now sorts after its sibling a2, so a1, a11 and a2 are renumbered along with foreign
+	if updated != 4 {
+		t.Errorf("rebuild updated %d nodes, expected 4", updated)
+	}
+	if report, err = Verify(db, &node{}); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Nodes != len(nodes) {
+		t.Fatalf("trees are invalid after rebuild: %+v", report)
+	}
+}
@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPClient is used for every request to identity providers
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Identity is the external account a provider authenticated
+type Identity struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	Name          string `json:"name"`
+	Avatar        string `json:"avatar"`
+}
+
+// AuthRequest holds the per-login values kept by the client between redirect and callback
+type AuthRequest struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// Provider runs the authorization code flow with PKCE against an identity provider
+type Provider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, req AuthRequest) (string, error)
+	Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error)
+}
+
+var (
+	providers = make(map[string]Provider)
+	locker    sync.RWMutex
+)
+
+func Register(p Provider) {
+	locker.Lock()
+	defer locker.Unlock()
+	providers[p.Name()] = p
+}
+
+func Find(name string) (Provider, bool) {
+	locker.RLock()
+	defer locker.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Names list registered provider names in order
+func Names() []string {
+	locker.RLock()
+	defer locker.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewAuthRequest generate a random state, nonce and PKCE verifier
+func NewAuthRequest() (AuthRequest, error) {
+	var req AuthRequest
+	var err error
+	if req.State, err = randomString(); err != nil {
+		return req, err
+	}
+	if req.Verifier, err = randomString(); err != nil {
+		return req, err
+	}
+	if req.Nonce, err = randomString(); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// Challenge derive the S256 PKCE code challenge of verifier
+func (req AuthRequest) Challenge() string {
+	sum := sha256.Sum256([]byte(req.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authCodeURL(endpoint string, clientID string, redirectURL string, scopes []string, req AuthRequest, extra url.Values) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", clientID)
+	query.Set("redirect_uri", redirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", req.State)
+	query.Set("code_challenge", req.Challenge())
+	query.Set("code_challenge_method", "S256")
+	for k, v := range extra {
+		query[k] = v
+	}
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	return endpoint + sep + query.Encode()
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeCode redeem code at the token endpoint along with the PKCE verifier
+func exchangeCode(ctx context.Context, endpoint string, clientID string, clientSecret string, redirectURL string, code string, req AuthRequest) (tokenResponse, error) {
+	var token tokenResponse
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	form.Set("code_verifier", req.Verifier)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if err := doJSON(httpReq, &token); err != nil {
+		return token, err
+	}
+	if token.Error != "" {
+		return token, fmt.Errorf("oauth: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return token, errors.New("oauth: server response missing access_token")
+	}
+	return token, nil
+}
+
+func getJSON(ctx context.Context, endpoint string, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return doJSON(req, v)
+}
+
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("oauth: %s responded %d: %s", req.URL.Host, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,83 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+const (
+	githubAuthURL   = "https://github.com/login/oauth/authorize"
+	githubTokenURL  = "https://github.com/login/oauth/access_token"
+	githubUserURL   = "https://api.github.com/user"
+	githubEmailsURL = "https://api.github.com/user/emails"
+)
+
+// GitHubProvider sign users in with a github oauth app, github does not speak oidc
+// so the identity is read from the rest api
+type GitHubProvider struct {
+	name         string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+}
+
+func NewGitHub(name, clientID, clientSecret, redirectURL string, scopes []string) *GitHubProvider {
+	if name == "" {
+		name = "github"
+	}
+	if len(scopes) == 0 {
+		scopes = []string{"read:user", "user:email"}
+	}
+	return &GitHubProvider{
+		name: name, clientID: clientID, clientSecret: clientSecret, redirectURL: redirectURL, scopes: scopes,
+	}
+}
+
+func (p *GitHubProvider) Name() string {
+	return p.name
+}
+
+func (p *GitHubProvider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	return authCodeURL(githubAuthURL, p.clientID, p.redirectURL, p.scopes, req, nil), nil
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error) {
+	var identity Identity
+	token, err := exchangeCode(ctx, githubTokenURL, p.clientID, p.clientSecret, p.redirectURL, code, req)
+	if err != nil {
+		return identity, err
+	}
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(ctx, githubUserURL, token.AccessToken, &user); err != nil {
+		return identity, err
+	}
+	if user.ID == 0 {
+		return identity, errors.New("oauth: github user has no id")
+	}
+	identity = Identity{
+		Provider: p.name, Subject: strconv.FormatInt(user.ID, 10), Name: user.Login, Avatar: user.AvatarURL,
+	}
+	// the public profile email may be unverified, only the primary verified address is trusted
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, githubEmailsURL, token.AccessToken, &emails); err != nil {
+		return identity, err
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			identity.Email, identity.EmailVerified = email.Email, true
+			break
+		}
+	}
+	return identity, nil
+}
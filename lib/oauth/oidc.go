@@ -0,0 +1,258 @@
+package oauth
+
+import (
+	// registers the EdDSA signing method so ed25519 id tokens verify
+	_ "app/util"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// discoveryTTL is how long discovery documents and signing keys are cached
+const discoveryTTL = time.Hour
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCProvider sign users in with any openid connect issuer, endpoints are
+// discovered from the issuer and id tokens are verified against its jwks
+type OIDCProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	locker    sync.Mutex
+	discovery *discovery
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func NewOIDC(name, issuer, clientID, clientSecret, redirectURL string, scopes []string) *OIDCProvider {
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCProvider{
+		name: name, issuer: strings.TrimSuffix(issuer, "/"), clientID: clientID,
+		clientSecret: clientSecret, redirectURL: redirectURL, scopes: scopes,
+	}
+}
+
+// NewGoogle is an oidc provider preset for google accounts
+func NewGoogle(name, clientID, clientSecret, redirectURL string, scopes []string) *OIDCProvider {
+	if name == "" {
+		name = "google"
+	}
+	return NewOIDC(name, "https://accounts.google.com", clientID, clientSecret, redirectURL, scopes)
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	d, err := p.load(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	extra := map[string][]string{"nonce": {req.Nonce}}
+	return authCodeURL(d.AuthorizationEndpoint, p.clientID, p.redirectURL, p.scopes, req, extra), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error) {
+	var identity Identity
+	d, err := p.load(ctx, false)
+	if err != nil {
+		return identity, err
+	}
+	token, err := exchangeCode(ctx, d.TokenEndpoint, p.clientID, p.clientSecret, p.redirectURL, code, req)
+	if err != nil {
+		return identity, err
+	}
+	if token.IDToken == "" {
+		return identity, errors.New("oauth: server response missing id_token")
+	}
+	claims, err := p.verify(ctx, token.IDToken, req.Nonce)
+	if err != nil {
+		return identity, err
+	}
+	identity.Provider = p.name
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Avatar, _ = claims["picture"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if identity.Subject == "" {
+		return identity, errors.New("oauth: id_token has no subject")
+	}
+	return identity, nil
+}
+
+// verify check signature, issuer, audience, expiry and nonce of an id token
+func (p *OIDCProvider) verify(ctx context.Context, idToken string, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}}
+	_, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oauth: invalid id_token: %v", err)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("oauth: id_token has no exp")
+	}
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("oauth: id_token issued by %s", iss)
+	}
+	if !claims.VerifyAudience(p.clientID, true) {
+		return nil, errors.New("oauth: id_token audience mismatch")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("oauth: id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// key find the verification key by kid, keys are refetched once when kid is unknown so rotation is picked up
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	for _, refresh := range []bool{false, true} {
+		if _, err := p.load(ctx, refresh); err != nil {
+			return nil, err
+		}
+		p.locker.Lock()
+		key, ok := p.keys[kid]
+		if !ok && kid == "" && len(p.keys) == 1 {
+			for _, k := range p.keys {
+				key, ok = k, true
+			}
+		}
+		p.locker.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("oauth: unknown key id %s", kid)
+}
+
+func (p *OIDCProvider) load(ctx context.Context, refresh bool) (*discovery, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if !refresh && p.discovery != nil && time.Since(p.fetchedAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+	var d discovery
+	if err := getJSON(ctx, p.issuer+"/.well-known/openid-configuration", "", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oauth: discovery issuer %s does not match %s", d.Issuer, p.issuer)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, d.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := parseJWK(k); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	p.discovery, p.keys, p.fetchedAt = &d, keys, time.Now()
+	return p.discovery, nil
+}
+
+func parseJWK(k jsonWebKey) (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
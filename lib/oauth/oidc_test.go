@@ -0,0 +1,193 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// fakeIssuer is an openid connect provider which hands out id_token for a single code,
+// the token endpoint checks the PKCE verifier against the challenge of the last authorization
+type fakeIssuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	claims    func(issuer string) jwt.MapClaims
+	// declared is the issuer named by the discovery document, the server url when empty
+	declared string
+	// kid is the key id put into id_token headers, the id of the published key when empty
+	kid string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		declared := f.declared
+		if declared == "" {
+			declared = f.URL
+		}
+		_ = json.NewEncoder(w).Encode(discovery{
+			Issuer: declared, AuthorizationEndpoint: f.URL + "/authorize",
+			TokenEndpoint: f.URL + "/token", JWKSURI: f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kty: "RSA", Kid: "k1", Use: "sig",
+			N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != "code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, f.claims(f.URL))
+		token.Header["kid"] = "k1"
+		if f.kid != "" {
+			token.Header["kid"] = f.kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": signed})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// authorize run the redirect leg, the fake issuer remembers the challenge of the returned url
+func (f *fakeIssuer) authorize(t *testing.T, p *OIDCProvider, req AuthRequest) url.Values {
+	t.Helper()
+	endpoint, err := p.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(endpoint, f.URL+"/authorize?") {
+		t.Fatalf("authorization endpoint was not discovered: %s", endpoint)
+	}
+	f.challenge = u.Query().Get("code_challenge")
+	return u.Query()
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := NewOIDC("fake", f.URL, "client", "secret", "http://app/callback", nil)
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := f.authorize(t, p, req)
+	expected := map[string]string{
+		"response_type": "code", "client_id": "client", "redirect_uri": "http://app/callback",
+		"scope": "openid email profile", "state": req.State, "nonce": req.Nonce,
+		"code_challenge": req.Challenge(), "code_challenge_method": "S256",
+	}
+	for k, v := range expected {
+		if got := query.Get(k); got != v {
+			t.Errorf("%s = %q, expected %q", k, got, v)
+		}
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	valid := func(issuer string, nonce string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": issuer, "aud": "client", "sub": "42", "nonce": nonce,
+			"exp": time.Now().Add(time.Minute).Unix(), "email": "a@example.com", "email_verified": true,
+		}
+	}
+	cases := []struct {
+		name     string
+		claims   func(claims jwt.MapClaims)
+		verifier string
+		kid      string
+		valid    bool
+	}{
+		{name: "valid", valid: true},
+		{name: "wrong verifier", verifier: "forged"},
+		{name: "unknown key", kid: "k2"},
+		{name: "wrong nonce", claims: func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }},
+		{name: "wrong issuer", claims: func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", claims: func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }},
+		{name: "expired", claims: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "without expiry", claims: func(claims jwt.MapClaims) { delete(claims, "exp") }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newFakeIssuer(t)
+			f.kid = c.kid
+			p := NewOIDC("fake", f.URL, "client", "secret", "http://app/callback", nil)
+			req, err := NewAuthRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.authorize(t, p, req)
+			f.claims = func(issuer string) jwt.MapClaims {
+				claims := valid(issuer, req.Nonce)
+				if c.claims != nil {
+					c.claims(claims)
+				}
+				return claims
+			}
+			if c.verifier != "" {
+				req.Verifier = c.verifier
+			}
+			identity, err := p.Exchange(context.Background(), "code", req)
+			if !c.valid {
+				if err == nil {
+					t.Fatalf("expected the exchange to be rejected, got %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Provider != "fake" || identity.Subject != "42" || identity.Email != "a@example.com" || !identity.EmailVerified {
+				t.Fatalf("unexpected identity %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.declared = "https://evil.example.com"
+	p := NewOIDC("fake", f.URL, "client", "secret", "http://app/callback", nil)
+	if _, err := p.AuthCodeURL(context.Background(), AuthRequest{}); err == nil {
+		t.Fatal("expected discovery of another issuer to be rejected")
+	}
+}
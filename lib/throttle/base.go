@@ -0,0 +1,91 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Throttle counts failures per key, once a key reaches Threshold failures it is
+// locked out for BaseDelay, doubling with every further failure up to MaxDelay.
+// Failures older than Window are forgotten.
+type Throttle struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func New(threshold int, baseDelay time.Duration, maxDelay time.Duration, window time.Duration) *Throttle {
+	return &Throttle{
+		Threshold: threshold, BaseDelay: baseDelay, MaxDelay: maxDelay, Window: window,
+		entries: make(map[string]*entry), lastSweep: time.Now(),
+	}
+}
+
+// Locked report whether key is locked out and how long the lock lasts
+func (t *Throttle) Locked(key string) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return false, 0
+	}
+	remain := time.Until(e.lockedUntil)
+	return remain > 0, remain
+}
+
+// Fail record a failure of key, returns the lockout duration when it causes one
+func (t *Throttle) Fail(key string) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.sweep(now)
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.lastFailure) > t.Window {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	if e.failures < t.Threshold {
+		return false, 0
+	}
+	delay := t.BaseDelay
+	for i := t.Threshold; i < e.failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	e.lockedUntil = now.Add(delay)
+	return true, delay
+}
+
+// Reset forget all failures of key
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+func (t *Throttle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.Window {
+		return
+	}
+	for key, e := range t.entries {
+		if now.Sub(e.lastFailure) > t.Window && now.After(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+	t.lastSweep = now
+}
@@ -1,90 +1,212 @@
 package ws
 
 import (
+	"encoding/json"
 	"sync"
+	"time"
 
-	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
 )
 
-var WebsocketServer = websocketServer{
-	Clients:    make([]*WebsocketConnection, 0),
-	Register:   make(chan *WebsocketConnection, 128),
-	UnRegister: make(chan *WebsocketConnection, 128),
+const (
+	// writeWait is how long a single write may take
+	writeWait = 10 * time.Second
+	// pongWait is how long a peer may stay silent, pings are sent well before it expires
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize limits messages read from peers
+	maxMessageSize = 4096
+	// sendBufferSize is how many outbound messages may queue up before the peer is
+	// considered too slow and dropped
+	sendBufferSize = 64
+	// historySize is how many delivered messages are kept for clients resuming a stream
+	historySize = 1024
+	// outboxSize is how many events may wait for the broker, further ones only reach this node
+	outboxSize = 256
+)
+
+var WebsocketServer = NewServer()
+
+// NewServer create a server with a node id of its own, which tells its messages apart on a broker
+func NewServer() *websocketServer {
+	return &websocketServer{
+		Node:       uuid.NewV4().String(),
+		Clients:    make(map[string][]*Client),
+		Topics:     make(map[string]map[*Client]struct{}),
+		Register:   make(chan *Client, 128),
+		UnRegister: make(chan *Client, 128),
+		history:    newHistory(historySize),
+		outbox:     make(chan relay, outboxSize),
+	}
 }
 
+// websocketServer is the delivery core shared by websocket and event stream clients.
+// It keeps clients of authenticated users keyed by user id, a user may hold several
+// at once, e.g. one per browser tab. Topics keeps the clients subscribed to each topic.
+// Messages are relayed through broker to the servers of other nodes when one is in use.
 type websocketServer struct {
-	Clients              []*WebsocketConnection
-	Register, UnRegister chan *WebsocketConnection
+	Clients              map[string][]*Client
+	Topics               map[string]map[*Client]struct{}
+	Register, UnRegister chan *Client
 	Locker               sync.Mutex
+	Node                 string
+	broker               Broker
+	history              *history
+	outbox               chan relay
+	// OnConnect is called in its own goroutine for every client once it receives messages
+	OnConnect func(c *Client)
+	// Authorize is asked whether a user may follow a topic, every valid topic is open when nil
+	Authorize func(userID string, topic string) bool
+	// OnDelivered is called in its own goroutine whenever a message sent to userID, from this or
+	// any other node, reached a client of the user connected to this node
+	OnDelivered func(userID string, data []byte)
 }
 
 func (s *websocketServer) Start() {
+	go s.relayOutbox()
 	for {
 		select {
 		case client := <-s.Register:
+			// the client may have closed before its registration got here
+			if client.closed() {
+				continue
+			}
 			s.Locker.Lock()
-			s.Clients = append(s.Clients, client)
+			s.Clients[client.UserID] = append(s.Clients[client.UserID], client)
 			s.Locker.Unlock()
+			s.connected(client)
 		case client := <-s.UnRegister:
 			s.Locker.Lock()
-			for i := 0; i < len(s.Clients); i++ {
-				if s.Clients[i].Key == client.Key {
-					s.Clients = append(s.Clients[0:i], s.Clients[i+1:]...)
-				}
-			}
+			s.detach(client)
 			s.Locker.Unlock()
 		}
 	}
 }
 
-func (s *websocketServer) RegisterConn(key string, unsafeConn *websocket.Conn) {
-	conn := &threadSafeConn{unsafeConn, sync.Mutex{}}
-	c := &WebsocketConnection{
-		Key: key, Conn: conn,
+// detach drop client and its subscriptions, expects s.Locker to be held
+func (s *websocketServer) detach(client *Client) {
+	conns := s.Clients[client.UserID]
+	for i := 0; i < len(conns); i++ {
+		if conns[i].ID == client.ID {
+			conns = append(conns[0:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(s.Clients, client.UserID)
+	} else {
+		s.Clients[client.UserID] = conns
+	}
+	for topic := range client.topics {
+		s.unsubscribe(client, topic)
 	}
-	s.Register <- c
 }
 
-func (s *websocketServer) FindClient(key string) *WebsocketConnection {
-	for _, c := range s.Clients {
-		if c.Key == key {
-			return c
-		}
+func (s *websocketServer) connected(c *Client) {
+	if s.OnConnect != nil {
+		go s.OnConnect(c)
 	}
-	return nil
 }
 
-func (s *websocketServer) Send(msg interface{}) {
-	for _, c := range s.Clients {
-		c.Conn.WriteJSON(msg)
+// Online tell whether userID has a client connected to this node
+func (s *websocketServer) Online(userID string) bool {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	return len(s.Clients[userID]) > 0
+}
+
+// FindClients list clients of userID
+func (s *websocketServer) FindClients(userID string) []*Client {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	conns := make([]*Client, len(s.Clients[userID]))
+	copy(conns, s.Clients[userID])
+	return conns
+}
+
+// Send send msg to every client
+func (s *websocketServer) Send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
 	}
+	return s.dispatch(relay{Kind: relayAll, Data: data})
 }
 
-func (s *websocketServer) SendTo(msg interface{}, key string) {
-	for _, c := range s.Clients {
-		if c.Key == key {
-			c.Conn.WriteJSON(msg)
-		}
+// SendTo send msg to every client of userID
+func (s *websocketServer) SendTo(msg interface{}, userID string) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
 	}
+	return s.dispatch(relay{Kind: relayUser, UserID: userID, Data: data})
 }
 
-func (s *websocketServer) UnRegisterConn(key string) {
-	c := s.FindClient(key)
-	s.UnRegister <- c
+// CloseUser close every client owned by userID
+func (s *websocketServer) CloseUser(userID string) {
+	s.dispatch(relay{Kind: relayClose, UserID: userID})
 }
 
-type WebsocketConnection struct {
-	Key  string
-	Conn *threadSafeConn
+// CloseClients close every client connected to this node, e.g. when it shuts down
+func (s *websocketServer) CloseClients() {
+	s.Locker.Lock()
+	conns := make([]*Client, 0)
+	for _, userConns := range s.Clients {
+		conns = append(conns, userConns...)
+	}
+	s.Locker.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
 }
 
-type threadSafeConn struct {
-	*websocket.Conn
-	sync.Mutex
+// CloseSession close the clients userID connected with during session sessionID
+func (s *websocketServer) CloseSession(userID string, sessionID string) {
+	s.dispatch(relay{Kind: relayClose, UserID: userID, SessionID: sessionID})
 }
 
-func (t *threadSafeConn) WriteJSON(v interface{}) error {
-	t.Lock()
-	defer t.Unlock()
-	return t.Conn.WriteJSON(v)
+// deliver enqueue r to the local clients it targets, every message gets
+// a sequence id and is kept in history so event streams can resume
+func (s *websocketServer) deliver(r relay) {
+	s.Locker.Lock()
+	if r.Kind == relayClose {
+		conns := make([]*Client, 0, len(s.Clients[r.UserID]))
+		for _, c := range s.Clients[r.UserID] {
+			if r.SessionID == "" || c.SessionID == r.SessionID {
+				conns = append(conns, c)
+			}
+		}
+		s.Locker.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		return
+	}
+	msg := outbound{id: s.history.add(r), data: r.Data}
+	targets := make(map[*Client]struct{})
+	switch r.Kind {
+	case relayAll:
+		for _, userConns := range s.Clients {
+			for _, c := range userConns {
+				targets[c] = struct{}{}
+			}
+		}
+	case relayUser:
+		for _, c := range s.Clients[r.UserID] {
+			targets[c] = struct{}{}
+		}
+	case relayTopics:
+		for _, topic := range r.Topics {
+			for c := range s.Topics[topic] {
+				targets[c] = struct{}{}
+			}
+		}
+	}
+	s.Locker.Unlock()
+	for c := range targets {
+		c.enqueue(msg)
+	}
+	if r.Kind == relayUser && len(targets) > 0 && s.OnDelivered != nil {
+		go s.OnDelivered(r.UserID, r.Data)
+	}
 }
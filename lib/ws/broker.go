@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Broker relays messages between the servers of every node, so that a user
+// connected to one node receives messages sent from another
+type Broker interface {
+	// Publish hand data to every subscriber, including the publishing node
+	Publish(ctx context.Context, data []byte) error
+	// Subscribe call handler for every published message until the broker is closed
+	Subscribe(handler func(data []byte)) error
+	Close() error
+}
+
+// publishTimeout bounds how long relaying a message to the broker may take
+const publishTimeout = 5 * time.Second
+
+const (
+	relayAll    = "all"
+	relayUser   = "user"
+	relayTopics = "topics"
+	relayClose  = "close"
+)
+
+// relay is a message delivered to local connections and passed on to other nodes
+type relay struct {
+	Node      string          `json:"node"`
+	Kind      string          `json:"kind"`
+	UserID    string          `json:"userID,omitempty"`
+	SessionID string          `json:"sessionID,omitempty"` // narrows relayClose down to one session
+	Topics    []string        `json:"topics,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// UseBroker relay messages of s through broker from now on
+func (s *websocketServer) UseBroker(broker Broker) error {
+	if err := broker.Subscribe(s.receive); err != nil {
+		return err
+	}
+	s.Locker.Lock()
+	s.broker = broker
+	s.Locker.Unlock()
+	return nil
+}
+
+// CloseBroker stop relaying through the broker and close it, messages only reach local
+// connections afterwards
+func (s *websocketServer) CloseBroker() error {
+	s.Locker.Lock()
+	broker := s.broker
+	s.broker = nil
+	s.Locker.Unlock()
+	if broker == nil {
+		return nil
+	}
+	return broker.Close()
+}
+
+// dispatch deliver r to local connections and publish it to other nodes
+func (s *websocketServer) dispatch(r relay) error {
+	s.deliver(r)
+	return s.publish(r)
+}
+
+// publish hand r to the broker for other nodes, it waits for the broker up to publishTimeout
+func (s *websocketServer) publish(r relay) error {
+	s.Locker.Lock()
+	broker := s.broker
+	s.Locker.Unlock()
+	if broker == nil {
+		return nil
+	}
+	r.Node = s.Node
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return broker.Publish(ctx, data)
+}
+
+// relayOutbox publish the relays queued by ForwardEvent one after another, so that
+// a slow broker holds up nothing but the queue
+func (s *websocketServer) relayOutbox() {
+	for r := range s.outbox {
+		_ = s.publish(r)
+	}
+}
+
+// receive deliver a message published by another node, messages of this node were delivered already
+func (s *websocketServer) receive(data []byte) {
+	var r relay
+	if err := json.Unmarshal(data, &r); err != nil || r.Node == s.Node {
+		return
+	}
+	s.deliver(r)
+}
+
+// MemoryBroker relays messages between servers living in the same process,
+// it is the default for a single node and lets multi node setups run locally
+type MemoryBroker struct {
+	locker   sync.RWMutex
+	handlers []func(data []byte)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: make([]func(data []byte), 0)}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, data []byte) error {
+	b.locker.RLock()
+	handlers := b.handlers
+	b.locker.RUnlock()
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(data []byte)) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.handlers = nil
+	return nil
+}
@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSBroker relays messages through a nats subject
+type NATSBroker struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSBroker connect to the nats server at url, e.g. nats://localhost:4222
+func NewNATSBroker(url string, subject string) (*NATSBroker, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSBroker{conn: conn, subject: subject}, nil
+}
+
+func (b *NATSBroker) Publish(ctx context.Context, data []byte) error {
+	return b.conn.Publish(b.subject, data)
+}
+
+func (b *NATSBroker) Subscribe(handler func(data []byte)) error {
+	_, err := b.conn.Subscribe(b.subject, func(msg *nats.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return err
+	}
+	return b.conn.Flush()
+}
+
+func (b *NATSBroker) Close() error {
+	b.conn.Close()
+	return nil
+}
@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisBroker relays messages through a redis pub/sub channel
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+	pubsub  *redis.PubSub
+}
+
+// NewRedisBroker connect to the redis at url, e.g. redis://localhost:6379/0
+func NewRedisBroker(url string, channel string) (*RedisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisBroker{client: client, channel: channel}, nil
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, data []byte) error {
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(handler func(data []byte)) error {
+	ctx := context.Background()
+	b.pubsub = b.client.Subscribe(ctx, b.channel)
+	// wait for the subscription so that nothing published afterwards is missed
+	if _, err := b.pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	go func() {
+		for msg := range b.pubsub.Channel() {
+			handler([]byte(msg.Payload))
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) Close() error {
+	if b.pubsub != nil {
+		b.pubsub.Close()
+	}
+	return b.client.Close()
+}
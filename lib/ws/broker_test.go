@@ -0,0 +1,218 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// startNodes start n servers relaying through one memory broker, like nodes sharing redis
+func startNodes(t *testing.T, n int) []*websocketServer {
+	t.Helper()
+	broker := NewMemoryBroker()
+	nodes := make([]*websocketServer, 0, n)
+	for i := 0; i < n; i++ {
+		s := NewServer()
+		if err := s.UseBroker(broker); err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		nodes = append(nodes, s)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+	return nodes
+}
+
+// connect register a client of userID on s and wait until it is online
+func connect(t *testing.T, s *websocketServer, userID string) *Client {
+	t.Helper()
+	return connectSession(t, s, userID, "")
+}
+
+// connectSession register a client of userID within session sessionID on s and wait until it is registered
+func connectSession(t *testing.T, s *websocketServer, userID string, sessionID string) *Client {
+	t.Helper()
+	c := newClient(s, userID, sessionID, nil)
+	s.Register <- c
+	deadline := time.Now().Add(time.Second)
+	for !registered(s, c) {
+		if time.Now().After(deadline) {
+			t.Fatal("client was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return c
+}
+
+func registered(s *websocketServer, c *Client) bool {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	for _, found := range s.Clients[c.UserID] {
+		if found == c {
+			return true
+		}
+	}
+	return false
+}
+
+// receive wait for the next message of c
+func receive(t *testing.T, c *Client) map[string]string {
+	t.Helper()
+	select {
+	case msg := <-c.send:
+		var v map[string]string
+		if err := json.Unmarshal(msg.data, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+// silent assert c received nothing more
+func silent(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case msg := <-c.send:
+		t.Fatalf("unexpected message %s", msg.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendToAcrossNodes(t *testing.T) {
+	nodes := startNodes(t, 2)
+	local := connect(t, nodes[0], "alice")
+	remote := connect(t, nodes[1], "alice")
+	other := connect(t, nodes[1], "bob")
+
+	if err := nodes[0].SendTo(map[string]string{"text": "hi"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Client{local, remote} {
+		if got := receive(t, c); got["text"] != "hi" {
+			t.Fatalf("unexpected message %v", got)
+		}
+		// the publishing node must not deliver its own message a second time
+		silent(t, c)
+	}
+	silent(t, other)
+}
+
+func TestPublishAcrossNodes(t *testing.T) {
+	nodes := startNodes(t, 2)
+	subscriber := connect(t, nodes[0], "alice")
+	bystander := connect(t, nodes[0], "bob")
+	if err := nodes[0].Subscribe(subscriber, "category:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nodes[1].Publish("category:1", map[string]string{"type": "category.updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, subscriber); got["type"] != "category.updated" {
+		t.Fatalf("unexpected message %v", got)
+	}
+	silent(t, subscriber)
+	silent(t, bystander)
+}
+
+func TestCloseUserAcrossNodes(t *testing.T) {
+	nodes := startNodes(t, 2)
+	remote := connect(t, nodes[1], "alice")
+
+	nodes[0].CloseUser("alice")
+	select {
+	case <-remote.done:
+	case <-time.After(time.Second):
+		t.Fatal("client on the other node was not closed")
+	}
+}
+
+func TestCloseSessionAcrossNodes(t *testing.T) {
+	nodes := startNodes(t, 2)
+	revoked := connectSession(t, nodes[1], "alice", "session-1")
+	kept := connectSession(t, nodes[1], "alice", "session-2")
+	key := connectSession(t, nodes[0], "alice", "")
+
+	nodes[0].CloseSession("alice", "session-1")
+	select {
+	case <-revoked.done:
+	case <-time.After(time.Second):
+		t.Fatal("client of the revoked session was not closed")
+	}
+	for _, c := range []*Client{kept, key} {
+		select {
+		case <-c.done:
+			t.Fatal("client of another session was closed")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestCloseBroker(t *testing.T) {
+	nodes := startNodes(t, 2)
+	local := connect(t, nodes[0], "alice")
+	remote := connect(t, nodes[1], "alice")
+
+	if err := nodes[0].CloseBroker(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].SendTo(map[string]string{"text": "hi"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, local)
+	silent(t, remote)
+}
+
+func TestDeliveryReportedByReceivingNode(t *testing.T) {
+	nodes := startNodes(t, 2)
+	reported := make(chan string, 4)
+	for _, s := range nodes {
+		s := s
+		s.OnDelivered = func(userID string, data []byte) { reported <- s.Node }
+	}
+	connect(t, nodes[1], "alice")
+
+	if err := nodes[0].SendTo(map[string]string{"text": "hi"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case node := <-reported:
+		if node != nodes[1].Node {
+			t.Fatal("delivery reported by a node without clients of the user")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery was never reported")
+	}
+	if err := nodes[0].SendTo(map[string]string{"text": "hi"}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reported:
+		t.Fatal("delivery reported for a user without clients")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCloseClientsStaysOnItsNode(t *testing.T) {
+	nodes := startNodes(t, 2)
+	alice := connect(t, nodes[0], "alice")
+	bob := connect(t, nodes[0], "bob")
+	remote := connect(t, nodes[1], "alice")
+
+	nodes[0].CloseClients()
+	for _, c := range []*Client{alice, bob} {
+		select {
+		case <-c.done:
+		case <-time.After(time.Second):
+			t.Fatal("client of the node was not closed")
+		}
+	}
+	select {
+	case <-remote.done:
+		t.Fatal("client of another node was closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
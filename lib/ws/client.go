@@ -0,0 +1,80 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// outbound is a message queued for a client, id is its sequence in history
+// or 0 for replies which are not kept there
+type outbound struct {
+	id   uint64
+	data []byte
+}
+
+// Client is a peer of any transport, messages reach it through a bounded queue
+// drained by the transport, which calls Close once the peer is gone
+type Client struct {
+	ID     string
+	UserID string
+	// SessionID is the login session the client authenticated with, empty for api keys
+	SessionID string
+
+	server    *websocketServer
+	send      chan outbound
+	done      chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+	// topics is guarded by server.Locker
+	topics map[string]struct{}
+}
+
+func newClient(s *websocketServer, userID string, sessionID string, onClose func()) *Client {
+	return &Client{
+		ID: uuid.NewV4().String(), UserID: userID, SessionID: sessionID, server: s, onClose: onClose,
+		send: make(chan outbound, sendBufferSize), done: make(chan struct{}),
+		topics: make(map[string]struct{}),
+	}
+}
+
+// enqueue queue msg without blocking, a peer whose queue is full is too slow and gets dropped
+func (c *Client) enqueue(msg outbound) {
+	select {
+	case <-c.done:
+	case c.send <- msg:
+	default:
+		c.Close()
+	}
+}
+
+// Send send msg to this client only, it is neither relayed nor kept in history
+func (c *Client) Send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.enqueue(outbound{data: data})
+	return nil
+}
+
+// Close close the client and unregister it, it is safe to call more than once
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.onClose != nil {
+			c.onClose()
+		}
+		c.server.UnRegister <- c
+	})
+}
+
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
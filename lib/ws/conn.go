@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebsocketConnection pumps a websocket peer, writes only happen on its write goroutine
+type WebsocketConnection struct {
+	*Client
+	conn *websocket.Conn
+}
+
+// RegisterConn register conn owned by userID within session sessionID and start pumping it,
+// the connection unregisters itself once it is closed
+func (s *websocketServer) RegisterConn(userID string, sessionID string, conn *websocket.Conn) *WebsocketConnection {
+	c := &WebsocketConnection{conn: conn}
+	c.Client = newClient(s, userID, sessionID, func() {
+		conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(writeWait))
+		conn.Close()
+	})
+	s.Register <- c.Client
+	go c.writePump()
+	go c.readPump()
+	return c
+}
+
+func (c *WebsocketConnection) readPump() {
+	defer c.Close()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.handle(data)
+	}
+}
+
+func (c *WebsocketConnection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Close()
+	}()
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package ws
+
+import (
+	"app/lib/event"
+	"encoding/json"
+	"time"
+)
+
+// eventMessage is the envelope domain events are pushed to clients in
+type eventMessage struct {
+	Type       string      `json:"type"`
+	Event      string      `json:"event"`
+	Topics     []string    `json:"topics"`
+	Payload    interface{} `json:"payload"`
+	OccurredAt time.Time   `json:"occurredAt"`
+}
+
+// ForwardEvent push e to connections subscribed to any of its topics, a connection
+// following several of them receives it once. It is meant to be subscribed to an event.Bus,
+// so it never blocks: other nodes are reached through a bounded queue and e is only
+// delivered to this node when the broker lags too far behind
+func (s *websocketServer) ForwardEvent(e event.Event) {
+	data, err := json.Marshal(eventMessage{
+		Type: "event", Event: e.Type, Topics: e.Topics, Payload: e.Payload, OccurredAt: e.OccurredAt,
+	})
+	if err != nil {
+		return
+	}
+	r := relay{Kind: relayTopics, Topics: e.Topics, Data: data}
+	s.deliver(r)
+	select {
+	case s.outbox <- r:
+	default:
+	}
+}
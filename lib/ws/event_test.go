@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"app/lib/event"
+	"context"
+	"testing"
+	"time"
+)
+
+// stalledBroker accepts subscribers but never finishes a publish, like an unreachable redis
+type stalledBroker struct{}
+
+func (stalledBroker) Publish(ctx context.Context, data []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (stalledBroker) Subscribe(handler func(data []byte)) error {
+	return nil
+}
+
+func (stalledBroker) Close() error {
+	return nil
+}
+
+func TestForwardEventAcrossNodes(t *testing.T) {
+	nodes := startNodes(t, 2)
+	subscriber := connect(t, nodes[1], "alice")
+	if err := nodes[1].Subscribe(subscriber, "category:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes[0].ForwardEvent(event.Event{Type: event.CategoryUpdated, Topics: []string{"category:1"}})
+	select {
+	case <-subscriber.send:
+	case <-time.After(time.Second):
+		t.Fatal("event never reached the other node")
+	}
+}
+
+func TestForwardEventDoesNotWaitForBroker(t *testing.T) {
+	s := NewServer()
+	if err := s.UseBroker(stalledBroker{}); err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	subscriber := connect(t, s, "alice")
+	if err := s.Subscribe(subscriber, "category:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// more events than the outbox holds, the ones left over are only delivered locally
+		for i := 0; i < outboxSize+2; i++ {
+			s.ForwardEvent(event.Event{Type: event.CategoryUpdated, Topics: []string{"category:1"}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("forwarding events waited for the broker")
+	}
+	select {
+	case <-subscriber.send:
+	default:
+		t.Fatal("event was not delivered locally")
+	}
+}
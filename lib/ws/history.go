@@ -0,0 +1,49 @@
+package ws
+
+// history is a bounded ring of delivered messages, guarded by server.Locker
+type history struct {
+	entries []historyEntry
+	start   int
+	size    int
+	seq     uint64
+}
+
+type historyEntry struct {
+	id    uint64
+	relay relay
+}
+
+func newHistory(capacity int) *history {
+	return &history{entries: make([]historyEntry, capacity)}
+}
+
+// add keep r and return its sequence id
+func (h *history) add(r relay) uint64 {
+	h.seq++
+	entry := historyEntry{id: h.seq, relay: r}
+	if h.size < len(h.entries) {
+		h.entries[(h.start+h.size)%len(h.entries)] = entry
+		h.size++
+	} else {
+		h.entries[h.start] = entry
+		h.start = (h.start + 1) % len(h.entries)
+	}
+	return h.seq
+}
+
+// since list entries after id, complete is false when some of them were evicted already
+// or id was never handed out, the client then missed messages that can't be replayed
+func (h *history) since(id uint64) (entries []historyEntry, complete bool) {
+	if id > h.seq {
+		return nil, false
+	}
+	entries = make([]historyEntry, 0)
+	for i := 0; i < h.size; i++ {
+		entry := h.entries[(h.start+i)%len(h.entries)]
+		if entry.id > id {
+			entries = append(entries, entry)
+		}
+	}
+	oldest := h.seq - uint64(h.size) + 1
+	return entries, id+1 >= oldest
+}
@@ -0,0 +1,33 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckOrigin build an upgrader origin check accepting the origins listed by allowed,
+// "*" accepts any origin. Requests without an Origin header come from non browser
+// clients and are accepted, without allowed origins only same host requests pass.
+func CheckOrigin(allowed func() []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		origins := allowed()
+		if len(origins) == 0 {
+			return strings.EqualFold(u.Host, r.Host)
+		}
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errStreamUnsupported = errors.New("streaming unsupported")
+
+// Reasons a resuming stream is reset instead of replayed, sent as {"reason": ...} with the reset event
+const (
+	// ResetInvalidID means Last-Event-ID was not issued by any node
+	ResetInvalidID = "invalid_id"
+	// ResetNodeChanged means the stream resumes on another node than the one which issued
+	// Last-Event-ID, history is kept per node so it can't be replayed
+	ResetNodeChanged = "node_changed"
+	// ResetHistoryExpired means messages after Last-Event-ID were evicted from history already
+	ResetHistoryExpired = "history_expired"
+)
+
+// attach register c following topics at once and list the messages it missed after lastEventID,
+// both happen under one lock so nothing falls between replay and live delivery. reason is set
+// when the missed messages can't be replayed
+func (s *websocketServer) attach(c *Client, topics []string, lastEventID string) (missed []historyEntry, reason string) {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	s.Clients[c.UserID] = append(s.Clients[c.UserID], c)
+	for _, topic := range topics {
+		if s.Topics[topic] == nil {
+			s.Topics[topic] = make(map[*Client]struct{})
+		}
+		s.Topics[topic][c] = struct{}{}
+		c.topics[topic] = struct{}{}
+	}
+	if lastEventID == "" {
+		return nil, ""
+	}
+	node, seq, ok := parseEventID(lastEventID)
+	if !ok {
+		return nil, ResetInvalidID
+	}
+	if node != s.Node {
+		return nil, ResetNodeChanged
+	}
+	entries, complete := s.history.since(seq)
+	if !complete {
+		reason = ResetHistoryExpired
+	}
+	missed = make([]historyEntry, 0, len(entries))
+	for _, entry := range entries {
+		if c.wants(entry.relay) {
+			missed = append(missed, entry)
+		}
+	}
+	return missed, reason
+}
+
+// wants tell whether r would have been delivered to c, expects server.Locker to be held
+func (c *Client) wants(r relay) bool {
+	switch r.Kind {
+	case relayAll:
+		return true
+	case relayUser:
+		return r.UserID == c.UserID
+	case relayTopics:
+		for _, topic := range r.Topics {
+			if _, ok := c.topics[topic]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseEventID(id string) (string, uint64, bool) {
+	sp := strings.SplitN(id, ":", 2)
+	if len(sp) != 2 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(sp[1], 10, 64)
+	return sp[0], seq, err == nil
+}
+
+// ServeStream stream messages for userID, logged in as session sessionID, and topics as server-sent events until the client goes away.
+// Event ids are "<node>:<seq>", passing the last one seen as lastEventID replays what was missed
+// while it is still kept in history, otherwise a "reset" event carrying one of the Reset reasons
+// tells the client to reload its state. History is kept by every node on its own, so only streams
+// resuming on the node they left, e.g. behind a load balancer with sticky sessions, are replayed.
+func (s *websocketServer) ServeStream(w http.ResponseWriter, r *http.Request, userID string, sessionID string, topics []string, lastEventID string) error {
+	if len(topics) > maxTopics {
+		return errTooManyTopics
+	}
+	for _, topic := range topics {
+		if err := s.canSubscribe(userID, topic); err != nil {
+			return err
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errStreamUnsupported
+	}
+	c := newClient(s, userID, sessionID, nil)
+	defer c.Close()
+	missed, reason := s.attach(c, topics, lastEventID)
+	s.connected(c)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// keeps nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if reason != "" {
+		fmt.Fprintf(w, "event: reset\ndata: {\"reason\":%q}\n\n", reason)
+	}
+	for _, entry := range missed {
+		s.writeEvent(w, entry.id, entry.relay.Data)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-c.done:
+			return nil
+		case msg := <-c.send:
+			if err := s.writeEvent(w, msg.id, msg.data); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent write data as an event, messages which are not kept in history carry no id
+// so they don't move the position the client resumes from
+func (s *websocketServer) writeEvent(w http.ResponseWriter, id uint64, data []byte) error {
+	if id == 0 {
+		_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "id: %s:%d\ndata: %s\n\n", s.Node, id, data)
+	return err
+}
@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveStream run a stream of alice resuming from lastEventID for a moment and return what it wrote
+func serveStream(t *testing.T, s *websocketServer, lastEventID string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	if err := s.ServeStream(w, r, "alice", "", nil, lastEventID); err != nil {
+		t.Fatal(err)
+	}
+	return w.Body.String()
+}
+
+func TestStreamResume(t *testing.T) {
+	nodes := startNodes(t, 2)
+	if err := nodes[0].SendTo(map[string]string{"text": "missed"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name        string
+		node        *websocketServer
+		lastEventID string
+		expected    string
+	}{
+		{"same node", nodes[0], nodes[0].Node + ":0", `"text":"missed"`},
+		{"other node", nodes[1], nodes[0].Node + ":0", `data: {"reason":"node_changed"}`},
+		{"expired", nodes[0], nodes[0].Node + ":100", `data: {"reason":"history_expired"}`},
+		{"invalid", nodes[0], "garbage", `data: {"reason":"invalid_id"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			body := serveStream(t, c.node, c.lastEventID)
+			if !strings.Contains(body, c.expected) {
+				t.Fatalf("expected %s in\n%s", c.expected, body)
+			}
+			if reset := strings.Contains(body, "event: reset"); reset == strings.Contains(c.expected, "text") {
+				t.Fatalf("unexpected reset in\n%s", body)
+			}
+		})
+	}
+	if body := serveStream(t, nodes[0], ""); strings.Contains(body, "event: reset") || strings.Contains(body, "missed") {
+		t.Fatalf("a fresh stream replayed or reset\n%s", body)
+	}
+}
@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// maxTopics limits subscriptions held by a single connection
+const maxTopics = 100
+
+// topicPattern matches the topics clients may follow, e.g. category:1, post:2 or user:<id>
+var topicPattern = regexp.MustCompile(`^(category|post|user):[A-Za-z0-9_-]{1,100}$`)
+
+var (
+	errInvalidTopic   = errors.New("invalid topic")
+	errTopicForbidden = errors.New("topic forbidden")
+	errTooManyTopics  = errors.New("too many topics")
+	errInvalidAction  = errors.New("invalid action")
+)
+
+// clientMessage is sent by peers to manage their subscriptions:
+// {"action": "subscribe", "topic": "category:1"}
+type clientMessage struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+}
+
+// replyMessage acknowledges a clientMessage, Error is set when it was refused
+type replyMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+	Error string `json:"error,omitempty"`
+}
+
+// canSubscribe tells whether userID may follow topic, personal topics are only open to their owner
+// and Authorize has the last word on the others
+func (s *websocketServer) canSubscribe(userID string, topic string) error {
+	if !topicPattern.MatchString(topic) {
+		return errInvalidTopic
+	}
+	if strings.HasPrefix(topic, "user:") && topic != "user:"+userID {
+		return errTopicForbidden
+	}
+	if s.Authorize != nil && !s.Authorize(userID, topic) {
+		return errTopicForbidden
+	}
+	return nil
+}
+
+func (c *WebsocketConnection) handle(data []byte) {
+	var msg clientMessage
+	var err error
+	if err = json.Unmarshal(data, &msg); err == nil {
+		switch msg.Action {
+		case "subscribe":
+			err = c.server.Subscribe(c.Client, msg.Topic)
+		case "unsubscribe":
+			c.server.Unsubscribe(c.Client, msg.Topic)
+		default:
+			err = errInvalidAction
+		}
+	}
+	reply := replyMessage{Type: msg.Action + "d", Topic: msg.Topic}
+	if err != nil {
+		reply.Type, reply.Error = "error", err.Error()
+	}
+	if data, err := json.Marshal(reply); err == nil {
+		c.enqueue(outbound{data: data})
+	}
+}
+
+// Subscribe add c to the subscribers of topic
+func (s *websocketServer) Subscribe(c *Client, topic string) error {
+	if err := s.canSubscribe(c.UserID, topic); err != nil {
+		return err
+	}
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	if _, ok := c.topics[topic]; ok {
+		return nil
+	}
+	if len(c.topics) >= maxTopics {
+		return errTooManyTopics
+	}
+	if c.closed() {
+		return nil
+	}
+	if s.Topics[topic] == nil {
+		s.Topics[topic] = make(map[*Client]struct{})
+	}
+	s.Topics[topic][c] = struct{}{}
+	c.topics[topic] = struct{}{}
+	return nil
+}
+
+func (s *websocketServer) Unsubscribe(c *Client, topic string) {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	s.unsubscribe(c, topic)
+}
+
+// unsubscribe expects s.Locker to be held
+func (s *websocketServer) unsubscribe(c *Client, topic string) {
+	delete(c.topics, topic)
+	if subscribers, ok := s.Topics[topic]; ok {
+		delete(subscribers, c)
+		if len(subscribers) == 0 {
+			delete(s.Topics, topic)
+		}
+	}
+}
+
+// Publish send msg to every connection subscribed to topic
+func (s *websocketServer) Publish(topic string, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.dispatch(relay{Kind: relayTopics, Topics: []string{topic}, Data: data})
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"app/api"
 	"app/lib/config"
+	"app/lib/event"
 	"app/lib/logger"
+	"app/lib/mailer"
+	"app/lib/oauth"
 	"app/lib/ws"
 	"app/middleware"
 	"app/repository/dao"
+	"app/repository/dto"
 	"app/util"
 	"context"
 	"fmt"
@@ -27,13 +31,59 @@ func setupApp() *gin.Engine {
 	app.Use(middleware.Recovery())
 	app.Use(middleware.Error())
 	app.Use(middleware.Cors())
-	app.Use(middleware.JWT(map[string]string{
-		"public": "post|get",
-	}))
+	jwtKeys := make([]util.JWTKey, 0)
+	for _, key := range config.JWT.Keys {
+		jwtKeys = append(jwtKeys, util.JWTKey{
+			Kid: key.Kid, PrivateKey: key.PrivateKey, PublicKey: key.PublicKey,
+		})
+	}
+	if err := util.InitJWT(config.JWT.SigningKey, config.App.JWTSecret, jwtKeys, config.JWT.Ephemeral); err != nil {
+		log.Fatal(err)
+	}
+	if config.Mail.Driver == "smtp" {
+		mailer.Default = mailer.NewSMTP(config.Mail.Host, config.Mail.Port, config.Mail.Username, config.Mail.Password, config.Mail.From)
+	} else {
+		mailer.Default = mailer.NewLog(config.Mail.Dir, config.Mail.From)
+	}
+	for _, p := range config.OAuth.Providers {
+		switch p.Type {
+		case "github":
+			oauth.Register(oauth.NewGitHub(p.Name, p.ClientID, p.ClientSecret, p.RedirectURL, p.Scopes))
+		case "google":
+			oauth.Register(oauth.NewGoogle(p.Name, p.ClientID, p.ClientSecret, p.RedirectURL, p.Scopes))
+		case "oidc":
+			oauth.Register(oauth.NewOIDC(p.Name, p.Issuer, p.ClientID, p.ClientSecret, p.RedirectURL, p.Scopes))
+		default:
+			log.Fatalf("unsupported oauth provider type %s", p.Type)
+		}
+	}
 	util.InitTranslator(config.App.Locale)
 	util.RegisterValidatorTranslations(config.App.Locale)
+	var broker ws.Broker
+	var err error
+	switch config.Websocket.Broker {
+	case "redis":
+		broker, err = ws.NewRedisBroker(config.Websocket.BrokerURL, config.Websocket.Channel)
+	case "nats":
+		broker, err = ws.NewNATSBroker(config.Websocket.BrokerURL, config.Websocket.Channel)
+	default:
+		broker = ws.NewMemoryBroker()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ws.WebsocketServer.UseBroker(broker); err != nil {
+		log.Fatal(err)
+	}
+	ws.WebsocketServer.OnConnect = dto.DeliverPendingNotifications
+	ws.WebsocketServer.OnDelivered = dto.NotificationDelivered
+	ws.WebsocketServer.Authorize = dto.CanFollowTopic
 	go ws.WebsocketServer.Start()
+	event.Subscribe(ws.WebsocketServer.ForwardEvent)
 	dao.Init(config.Database.URL)
+	if err := dao.InitRoles(config.App.GroupAdminRole, config.App.DefaultRole); err != nil {
+		log.Fatal(err)
+	}
 	api.ApplyRoutes(app)
 	return app
 }
@@ -50,6 +100,8 @@ func main() {
 		Handler: app,
 	}
 
+	// hijacked websockets and endless event streams are not waited for by Shutdown, they are told to go
+	server.RegisterOnShutdown(ws.WebsocketServer.CloseClients)
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("failed to listen: %s\n", err)
@@ -64,12 +116,16 @@ func main() {
 	log.Println("shutdown gracefully, press ctrl+c force shutdown")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	// requests and clients finish while the broker and db are still there
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("failed to shutdown server: ", err)
+	}
+	if err := ws.WebsocketServer.CloseBroker(); err != nil {
+		log.Fatal("failed to close broker: ", err)
+	}
 	if err := dao.Close(); err != nil {
 		log.Fatal("failed to close db: ", err)
 	}
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal("failed to shutdown server: ", err)
-	}
 	log.Println("server exiting")
 	// shutdown.NewHook().Close(
 	// 	func() {
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"app/repository/dao"
+	"errors"
+	"strings"
+)
+
+// decodeAPIKey resolve key into the same auth claims an access token carries,
+// scopes and apiKey are added so that permissions can be narrowed to the key
+func decodeAPIKey(key string) (interface{}, error) {
+	exists, found := dao.FindAPIKey(key)
+	if !exists || !found.IsUsable() || found.User == nil || !found.User.IsActived {
+		return nil, errors.New("API密钥无效")
+	}
+	roles, err := found.User.RoleNames()
+	if err != nil {
+		return nil, err
+	}
+	if err := found.Touch(); err != nil {
+		return nil, err
+	}
+	scopes := make([]string, 0)
+	if found.Scopes != "" {
+		scopes = strings.Split(found.Scopes, ",")
+	}
+	return map[string]interface{}{
+		"id": found.User.ID, "username": found.User.Username, "roles": roles,
+		"amr": []string{"apikey"}, "scopes": scopes, "apiKey": found.ID,
+	}, nil
+}
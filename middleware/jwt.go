@@ -1,69 +1,157 @@
 package middleware
 
 import (
-	"app/lib/config"
+	"app/repository/dao"
 	"app/util"
 	"errors"
-	"regexp"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func isMethodAllowed(method string, methods []string) bool {
-	for i := 0; i < len(methods); i++ {
-		if method == methods[i] {
-			return true
+// decodeAuthorization verify the bearer token and the session it belongs to,
+// the session is touched with ip of the client
+func decodeAuthorization(headerStr string, ip string) (interface{}, error) {
+	sp := strings.Split(headerStr, "Bearer ")
+	if len(sp) <= 1 {
+		return nil, errors.New("授权头信息不合法")
+	}
+	tokenStr := sp[1]
+	token, err := util.DecodeToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	auth, _ := token["auth"].(map[string]interface{})
+	if sid, ok := auth["sid"].(string); ok {
+		exists, session := dao.FindSession(sid)
+		if !exists || session.IsRevoked() {
+			return nil, errors.New("会话已失效")
+		}
+		if err := session.Touch(ip); err != nil {
+			return nil, err
 		}
 	}
-	return false
+	return token["auth"], nil
 }
 
-func matchRules(rules map[string]string, target string, method string) (bool, error) {
-	for rule, m := range rules {
-		matched, err := regexp.MatchString(rule, target)
-		if err != nil {
-			return false, err
+// authenticate resolve auth claims from a bearer token, an "Authorization: ApiKey ..."
+// header or an X-API-Key header, auth is nil when the request carries no credential
+func authenticate(c *gin.Context) (interface{}, error) {
+	if key := c.Request.Header.Get("X-API-Key"); key != "" {
+		return decodeAPIKey(key)
+	}
+	headerStr := c.Request.Header.Get("Authorization")
+	if headerStr == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(headerStr, "ApiKey ") {
+		return decodeAPIKey(strings.TrimPrefix(headerStr, "ApiKey "))
+	}
+	return decodeAuthorization(headerStr, c.ClientIP())
+}
+
+// errKeyNotAllowed rejects api keys on routes which were not registered through AcceptKeys
+var errKeyNotAllowed = errors.New("API密钥不可用于此接口")
+
+// Authenticate requires a valid bearer token or api key and stores its claims as "auth",
+// api keys are only accepted on routes registered through AcceptKeys
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth, err := authenticate(c)
+		if err == nil && auth == nil {
+			err = errors.New("授权头信息为空")
 		}
-		if matched {
-			return isMethodAllowed(strings.ToLower(method), strings.Split(strings.ToLower(m), "|")), nil
+		if err == nil && isAPIKey(auth) && !acceptsKeys(c) {
+			err = errKeyNotAllowed
 		}
+		if err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Set("auth", auth)
+		c.Next()
 	}
-	return false, nil
 }
 
-func JWT(unless map[string]string) gin.HandlerFunc {
+// OptionalAuthenticate stores claims as "auth" when a credential is present,
+// anonymous requests pass through but an invalid credential is still rejected
+func OptionalAuthenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		matched, err := matchRules(unless, c.Request.URL.String(), c.Request.Method)
+		auth, err := authenticate(c)
 		if err != nil {
 			_ = c.Error(err)
 			c.Abort()
 			return
 		}
-		if matched {
-			c.Next()
-			return
+		if auth != nil {
+			c.Set("auth", auth)
 		}
-		headerStr := c.Request.Header.Get("Authorization")
-		if headerStr == "" {
-			_ = c.Error(errors.New("授权头信息为空"))
-			c.Abort()
-			return
+		c.Next()
+	}
+}
+
+// streamToken read the access token of a websocket handshake or an event stream, browsers can't
+// set headers on those so the token comes as ?token= or as "Sec-WebSocket-Protocol: bearer, <token>"
+func streamToken(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	protocols := strings.Split(c.GetHeader("Sec-WebSocket-Protocol"), ",")
+	if len(protocols) == 2 && strings.TrimSpace(protocols[0]) == "bearer" {
+		return strings.TrimSpace(protocols[1])
+	}
+	return ""
+}
+
+// StreamAuthenticate requires a valid token on a websocket handshake or an event stream,
+// it is taken from the query or subprotocols when present, otherwise from the usual headers
+func StreamAuthenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var auth interface{}
+		var err error
+		if token := streamToken(c); token != "" {
+			auth, err = decodeAuthorization("Bearer "+token, c.ClientIP())
+		} else {
+			auth, err = authenticate(c)
 		}
-		sp := strings.Split(headerStr, "Bearer ")
-		if len(sp) <= 1 {
-			_ = c.Error(errors.New("授权头信息不合法"))
-			c.Abort()
-			return
+		if err == nil && auth == nil {
+			err = errors.New("授权头信息为空")
+		}
+		if err == nil && isAPIKey(auth) && !acceptsKeys(c) {
+			err = errKeyNotAllowed
 		}
-		tokenStr := sp[1]
-		token, err := util.DecodeToken(tokenStr, config.App.JWTSecret)
 		if err != nil {
 			_ = c.Error(err)
 			c.Abort()
 			return
 		}
-		c.Set("auth", token["auth"])
+		c.Set("auth", auth)
 		c.Next()
 	}
 }
+
+// isAPIKey tell whether auth claims were resolved from an api key
+func isAPIKey(auth interface{}) bool {
+	claims, _ := auth.(map[string]interface{})
+	_, ok := claims["apiKey"]
+	return ok
+}
+
+// JWT authenticates every request except those matched by unless,
+// kept for backward compatibility, prefer declaring Authenticate on route groups.
+// It panics if any rule of unless is not a valid regexp.
+func JWT(unless map[string]string) gin.HandlerFunc {
+	matcher, err := NewRouteMatcher(unless)
+	if err != nil {
+		panic(err)
+	}
+	authenticate := Authenticate()
+	return func(c *gin.Context) {
+		if matcher.Match(c.Request.URL.Path, c.Request.Method) {
+			c.Next()
+			return
+		}
+		authenticate(c)
+	}
+}
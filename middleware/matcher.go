@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type routeRule struct {
+	pattern *regexp.Regexp
+	methods []string
+}
+
+// RouteMatcher matches request paths against regexp rules, each rule owns
+// "|" separated methods like "post|get". Only the path is matched, the
+// query string never takes part in matching.
+type RouteMatcher struct {
+	rules []routeRule
+}
+
+// NewRouteMatcher compile rules, rules are tried in the lexical order of their patterns
+func NewRouteMatcher(rules map[string]string) (*RouteMatcher, error) {
+	patterns := make([]string, 0, len(rules))
+	for pattern := range rules {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	m := &RouteMatcher{rules: make([]routeRule, 0, len(patterns))}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, routeRule{
+			pattern: compiled,
+			methods: strings.Split(strings.ToLower(rules[pattern]), "|"),
+		})
+	}
+	return m, nil
+}
+
+// Match report whether path is covered by the first matching rule and method is allowed by it
+func (m *RouteMatcher) Match(path string, method string) bool {
+	for _, rule := range m.rules {
+		if rule.pattern.MatchString(path) {
+			return isMethodAllowed(strings.ToLower(method), rule.methods)
+		}
+	}
+	return false
+}
+
+func isMethodAllowed(method string, methods []string) bool {
+	for i := 0; i < len(methods); i++ {
+		if method == methods[i] {
+			return true
+		}
+	}
+	return false
+}
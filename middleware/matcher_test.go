@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	matcher, err := NewRouteMatcher(map[string]string{
+		`^/api/v1/public/`:   "get|post",
+		`^/api/v1/post/\d+$`: "GET",
+		`^/api/v1/post`:      "post",
+		`public`:             "get",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		target string
+		method string
+		match  bool
+	}{
+		{"/api/v1/public/login", "POST", true},
+		{"/api/v1/public/login", "get", true},
+		{"/api/v1/public/login", "DELETE", false},
+		// the first rule in lexical order decides, ^/api/v1/post sorts before ^/api/v1/post/\d+$
+		{"/api/v1/post/1", "GET", false},
+		{"/api/v1/post/1", "POST", true},
+		{"/api/v1/post", "GET", false},
+		{"/api/v1/user", "GET", false},
+		// the query string never takes part in matching, not even against a bare "public" rule
+		{"/api/v1/user?x=public", "GET", false},
+		{"/api/v1/user?redirect=/api/v1/public/login", "POST", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		if got := matcher.Match(req.URL.Path, req.Method); got != c.match {
+			t.Errorf("%s %s matched %v, expected %v", c.method, c.target, got, c.match)
+		}
+	}
+}
+
+func TestRouteMatcherInvalidPattern(t *testing.T) {
+	if _, err := NewRouteMatcher(map[string]string{`^/api/(`: "get"}); err == nil {
+		t.Fatal("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestRouteMatcherEmpty(t *testing.T) {
+	matcher, err := NewRouteMatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matcher.Match("/api/v1/public/login", "GET") {
+		t.Fatal("expected nothing to match without rules")
+	}
+}
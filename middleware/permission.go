@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"app/lib/config"
+	"app/repository/dao"
+	"errors"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authStrings read a string list claim of auth, decoded tokens hold []interface{}
+func authStrings(auth map[string]interface{}, key string) []string {
+	values := make([]string, 0)
+	switch v := auth[key].(type) {
+	case []string:
+		values = append(values, v...)
+	case []interface{}:
+		for _, item := range v {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// hasScopes tell whether an api key carries all scopes, other credentials are not narrowed by scopes
+func hasScopes(auth map[string]interface{}, scopes []string) bool {
+	if _, ok := auth["apiKey"]; !ok {
+		return true
+	}
+	granted := authStrings(auth, "scopes")
+	for _, scope := range scopes {
+		if !containsString(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPermission(auth map[string]interface{}, perms []string) (bool, error) {
+	if !hasScopes(auth, perms) {
+		return false, nil
+	}
+	roles := authStrings(auth, "roles")
+	if containsString(roles, config.App.GroupAdminRole) {
+		if !config.App.AdminRequireMFA || containsString(authStrings(auth, "amr"), "otp") {
+			return true, nil
+		}
+		// admin role is ignored until the login passed a second factor
+		granted := make([]string, 0, len(roles))
+		for _, role := range roles {
+			if role != config.App.GroupAdminRole {
+				granted = append(granted, role)
+			}
+		}
+		roles = granted
+	}
+	granted, err := dao.FindPermissionNames(roles)
+	if err != nil {
+		return false, err
+	}
+	for _, perm := range perms {
+		if !containsString(granted, perm) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func containsString(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+func authorize(c *gin.Context, perms []string) {
+	allowed, err := hasPermission(c.GetStringMap("auth"), perms)
+	if err != nil {
+		_ = c.Error(err)
+		c.Abort()
+		return
+	}
+	if !allowed {
+		_ = c.Error(errors.New("没有权限"))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// Allowed tell whether the current request owns all perms, for handlers whose checks depend on the request,
+// must run after JWT
+func Allowed(c *gin.Context, perms ...string) (bool, error) {
+	return hasPermission(c.GetStringMap("auth"), perms)
+}
+
+// Permission only let requests whose roles own all perms through, must run after JWT
+func Permission(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorize(c, perms)
+	}
+}
+
+// SelfOrPermission behaves like Permission but always allows users acting on themselves,
+// param names the route param holding the target user id
+func SelfOrPermission(param string, perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := c.GetStringMap("auth")
+		if id, ok := auth["id"].(string); ok && id == c.Param(param) && hasScopes(auth, perms) {
+			c.Next()
+			return
+		}
+		authorize(c, perms)
+	}
+}
+
+// Scope only let api keys carrying all scopes through, other credentials pass, must run after Authenticate.
+// Api keys only reach routes registered through AcceptKeys
+func Scope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasScopes(c.GetStringMap("auth"), scopes) {
+			_ = c.Error(errors.New("没有权限"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// keyRoutes are the routes api keys may reach keyed by method and full path, see AcceptKeys
+var keyRoutes = struct {
+	sync.RWMutex
+	routes map[string]bool
+}{routes: make(map[string]bool)}
+
+// KeyRoutes registers routes of a group which api keys may reach, each of them must check
+// the scopes of the key through Scope, Permission or SelfOrPermission
+type KeyRoutes struct {
+	group *gin.RouterGroup
+}
+
+// AcceptKeys open the routes registered through the returned KeyRoutes to api keys, Authenticate
+// and StreamAuthenticate refuse keys on every other route, so a route open to every user doesn't
+// become open to every key
+func AcceptKeys(group *gin.RouterGroup) KeyRoutes {
+	return KeyRoutes{group: group}
+}
+
+func (r KeyRoutes) Handle(method string, relativePath string, handlers ...gin.HandlerFunc) {
+	keyRoutes.Lock()
+	keyRoutes.routes[method+" "+path.Join(r.group.BasePath(), relativePath)] = true
+	keyRoutes.Unlock()
+	r.group.Handle(method, relativePath, handlers...)
+}
+
+func (r KeyRoutes) GET(relativePath string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodGet, relativePath, handlers...)
+}
+
+func (r KeyRoutes) POST(relativePath string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodPost, relativePath, handlers...)
+}
+
+func (r KeyRoutes) PUT(relativePath string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodPut, relativePath, handlers...)
+}
+
+func (r KeyRoutes) DELETE(relativePath string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodDelete, relativePath, handlers...)
+}
+
+// acceptsKeys tell whether the route of c was registered through AcceptKeys
+func acceptsKeys(c *gin.Context) bool {
+	keyRoutes.RLock()
+	defer keyRoutes.RUnlock()
+	return keyRoutes.routes[c.Request.Method+" "+c.FullPath()]
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyAuth fake the claims Authenticate stores for an api key carrying scopes
+func keyAuth(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("auth", map[string]interface{}{"id": "u1", "apiKey": "k1", "scopes": scopes})
+	}
+}
+
+func TestAcceptKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// runs ahead of the guards like Authenticate does and stops the chain there
+	group := r.Group("/keys", func(c *gin.Context) {
+		if acceptsKeys(c) {
+			c.AbortWithStatus(http.StatusOK)
+		} else {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	})
+	handler := func(c *gin.Context) {}
+	keys := AcceptKeys(group)
+	group.GET("/open", handler)
+	group.GET("/permitted", Permission("user:delete"), handler)
+	keys.GET("/scoped", Scope("post:write"), handler)
+	keys.PUT("/self/:id", SelfOrPermission("id", "user:update"), handler)
+	// wrapped guards and closures are registered all the same
+	keys.POST("/wrapped", func(c *gin.Context) { Scope("post:write")(c) }, handler)
+	cases := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/keys/open", http.StatusForbidden},
+		{http.MethodGet, "/keys/permitted", http.StatusForbidden},
+		{http.MethodGet, "/keys/scoped", http.StatusOK},
+		{http.MethodPut, "/keys/self/u1", http.StatusOK},
+		{http.MethodPost, "/keys/wrapped", http.StatusOK},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, c.target, nil))
+		if w.Code != c.status {
+			t.Errorf("%s %s responded %d, expected %d", c.method, c.target, w.Code, c.status)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name   string
+		auth   gin.HandlerFunc
+		guard  gin.HandlerFunc
+		target string
+		status int
+	}{
+		{"key with scope", keyAuth("post:write"), Scope("post:write"), "/x/u2", http.StatusOK},
+		{"key without scope", keyAuth("category:write"), Scope("post:write"), "/x/u2", http.StatusForbidden},
+		{"token", func(c *gin.Context) { c.Set("auth", map[string]interface{}{"id": "u1"}) }, Scope("post:write"), "/x/u2", http.StatusOK},
+		{"key acting on itself with scope", keyAuth("user:update"), SelfOrPermission("id", "user:update"), "/x/u1", http.StatusOK},
+		{"key acting on itself without scope", keyAuth(), SelfOrPermission("id", "user:update"), "/x/u1", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(func(ctx *gin.Context) {
+				ctx.Next()
+				if len(ctx.Errors) > 0 {
+					ctx.Status(http.StatusForbidden)
+				}
+			})
+			r.GET("/x/:id", c.auth, c.guard, func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.target, nil))
+			if w.Code != c.status {
+				t.Fatalf("responded %d, expected %d", w.Code, c.status)
+			}
+		})
+	}
+}
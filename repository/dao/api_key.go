@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"app/util"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix marks personal api keys so they are easy to spot in logs and secret scanners
+const APIKeyPrefix = "ak_"
+
+// APIKey is a personal, named credential for scripts, only the hash of the key is persisted.
+// Scopes are comma separated permissions limiting what the key can do on behalf of its user.
+type APIKey struct {
+	BaseModel
+	ID         string          `gorm:"size:100;not null;primaryKey" json:"id"`
+	UserID     string          `gorm:"size:100;index;not null" json:"userID"`
+	Name       string          `gorm:"size:100;not null" json:"name"`
+	Prefix     string          `gorm:"size:20;not null" json:"prefix"`
+	KeyHash    string          `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Scopes     string          `gorm:"type:text" json:"scopes"`
+	ExpiredAt  *util.LocalTime `json:"expiredAt"`
+	LastUsedAt *util.LocalTime `json:"lastUsedAt"`
+	RevokedAt  *util.LocalTime `json:"revokedAt"`
+	User       *User           `binding:"-" json:"user,omitempty"`
+}
+
+// Create generate the secret key, it's only returned here
+func (m APIKey) Create() (APIKey, string, error) {
+	secret, err := util.RandomToken(24)
+	if err != nil {
+		return m, "", err
+	}
+	key := APIKeyPrefix + secret
+	m.ID = uuid.NewV4().String()
+	m.Prefix = key[:len(APIKeyPrefix)+6]
+	m.KeyHash = util.HashToken(key)
+	if err := db.Create(&m).Error; err != nil {
+		return m, "", err
+	}
+	return m, key, nil
+}
+
+func (m APIKey) IsUsable() bool {
+	if m.RevokedAt != nil {
+		return false
+	}
+	return m.ExpiredAt == nil || m.ExpiredAt.After(time.Now())
+}
+
+// Touch record the key has been used, writes at most once per minute
+func (m APIKey) Touch() error {
+	if m.LastUsedAt != nil && time.Since(m.LastUsedAt.Time) < time.Minute {
+		return nil
+	}
+	return db.Model(&APIKey{}).Where("id = ?", m.ID).Update("last_used_at", time.Now()).Error
+}
+
+func (m APIKey) Revoke() error {
+	return db.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", m.ID).Update("revoked_at", time.Now()).Error
+}
+
+// FindAPIKey look key up by its hash along with its user
+func FindAPIKey(key string) (bool, APIKey) {
+	var one APIKey
+	err := db.Preload("User").Where("key_hash = ?", util.HashToken(key)).First(&one).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	return !notFound, one
+}
+
+func FindUserAPIKey(userID string, id string) (APIKey, error) {
+	var one APIKey
+	if err := db.First(&one, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return one, err
+	}
+	return one, nil
+}
+
+func FindAPIKeys(options map[string]interface{}) ([]APIKey, error) {
+	var rows []APIKey
+	if err := db.Scopes(applyQueryOptions(options)).Find(&rows).Error; err != nil {
+		return rows, err
+	}
+	return rows, nil
+}
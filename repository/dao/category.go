@@ -5,13 +5,16 @@ import (
 	"app/util"
 	"database/sql"
 	"errors"
+	"strconv"
 
+	uuid "github.com/satori/go.uuid"
 	"gorm.io/gorm"
 )
 
 type Category struct {
 	ID            int64          `gorm:"primaryKey;autoIncrement" nestedset:"id" json:"id"`
-	Name          string         `gorm:"size:200;uniqueIndex;not null" json:"name"`
+	OwnerID       string         `gorm:"size:100;not null;default:'';uniqueIndex:idx_category_owner_name,priority:1" nestedset:"scope" json:"ownerID"`
+	Name          string         `gorm:"size:200;not null;uniqueIndex:idx_category_owner_name,priority:2" json:"name"`
 	Description   string         `gorm:"type:text" json:"description"`
 	Amount        uint           `gorm:"default:0" binding:"-" json:"amount"`
 	Posts         []Post         `gorm:"foreignkey:CategoryID" binding:"-" json:"posts"`
@@ -21,23 +24,194 @@ type Category struct {
 	Lft           int            `nestedset:"lft" json:"right"`
 	Depth         int            `nestedset:"depth" json:"depth"`
 	ChildrenCount int            `nestedset:"children_count" json:"childrenCount"`
-	Children      []Category     `gorm:"-" binding:"-" json:"children"`
+	Children      []Category     `gorm:"-" binding:"-" nestedset:"children" json:"children"`
 	Parents       []Category     `gorm:"-" binding:"-" json:"parents"`
 	CreatedAt     util.LocalTime `json:"createdAt"`
 	UpdatedAt     util.LocalTime `json:"updatedAt"`
 	DeletedAt     util.DeletedAt `gorm:"index" json:"deletedAt"`
 }
 
-func (m Category) Create(parent *Category) (Category, error) {
+// Create add m as last child of parent in the tree of parent, or as a root when parent is nil
+func (m Category) Create(tx *gorm.DB, parent *Category) (Category, error) {
+	if parent == nil {
+		m.ParentID = sql.NullInt64{}
+		if err := nestedset.Create(conn(tx), &m, nil); err != nil {
+			return m, err
+		}
+		return m, nil
+	}
+	m.OwnerID = parent.OwnerID
 	m.ParentID = sql.NullInt64{Valid: true, Int64: parent.ID}
-	if err := nestedset.Create(db, &m, parent); err != nil {
+	if err := nestedset.Create(conn(tx), &m, parent); err != nil {
 		return m, err
 	}
 	return m, nil
 }
 
-func (m Category) MoveTo(parent *Category) error {
-	return nestedset.MoveTo(db, m, parent, nestedset.MoveDirectionInner)
+// MoveTo move m relative to node to, into it as first child or next to it as sibling,
+// lft and rgt of both must be current within tx
+func (m Category) MoveTo(tx *gorm.DB, to *Category, direction nestedset.MoveDirection) error {
+	if m.OwnerID != to.OwnerID {
+		return errors.New("不可跨分类树移动")
+	}
+	return nestedset.MoveTo(conn(tx), m, to, direction)
+}
+
+// Reload read m again within tx, lft and rgt shift whenever the tree changes
+func (m Category) Reload(tx *gorm.DB) (Category, error) {
+	var one Category
+	err := conn(tx).First(&one, "id = ?", m.ID).Error
+	return one, err
+}
+
+// Ways posts follow a copied category
+const (
+	CopyPostsNone      = "none"
+	CopyPostsMove      = "move"
+	CopyPostsDuplicate = "duplicate"
+)
+
+// CategoryCopy reports what copying categories did
+type CategoryCopy struct {
+	// Copies maps the copied categories to their copies
+	Copies map[int64]int64
+	// PostIDs maps the copied categories to the posts moved out of them
+	PostIDs map[int64][]string
+}
+
+// CopyTo copy m and its descendants into parent as its last child, names taken in the tree of parent
+// get a suffix. Posts of the copied categories are left behind, or moved along or duplicated as userID
+// when they are public or belong to userID
+func (m Category) CopyTo(tx *gorm.DB, parent *Category, posts string, userID string) (Category, CategoryCopy, error) {
+	var copied Category
+	var report CategoryCopy
+	err := nestedset.Transaction(conn(tx), func(tx *gorm.DB) error {
+		report = CategoryCopy{PostIDs: make(map[int64][]string)}
+		ids, err := nestedset.CopyTo(tx, m, parent, func(tx *gorm.DB, row interface{}) error {
+			c := row.(*Category)
+			c.CreatedAt, c.UpdatedAt, c.Amount = util.LocalTime{}, util.LocalTime{}, 0
+			name, err := copyName(tx.Model(&Category{}).Where("owner_id = ?", c.OwnerID), "name", c.Name)
+			c.Name = name
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		report.Copies = ids
+		affected := make([]int64, 0, len(ids)*2)
+		for from, to := range ids {
+			affected = append(affected, from, to)
+		}
+		switch posts {
+		case CopyPostsMove:
+			for from, to := range ids {
+				query := tx.Model(&Post{}).Where("category_id = ? AND (is_public = ? OR user_id = ?)", from, true, userID)
+				var moved []string
+				if err := nestedset.Locking(query.Session(&gorm.Session{})).Pluck("id", &moved).Error; err != nil {
+					return err
+				}
+				if len(moved) == 0 {
+					continue
+				}
+				if err := tx.Model(&Post{}).Where("id IN (?)", moved).Update("category_id", to).Error; err != nil {
+					return err
+				}
+				report.PostIDs[from] = moved
+			}
+		case CopyPostsDuplicate:
+			from := make([]int64, 0, len(ids))
+			for id := range ids {
+				from = append(from, id)
+			}
+			var rows []Post
+			err := tx.Where("category_id IN (?) AND (is_public = ? OR user_id = ?)", from, true, userID).Find(&rows).Error
+			if err != nil {
+				return err
+			}
+			for _, row := range rows {
+				row.ID = uuid.NewV4().String()
+				row.CreatedAt, row.UpdatedAt = util.LocalTime{}, util.LocalTime{}
+				row.CategoryID, row.UserID, row.Liked = uint(ids[int64(row.CategoryID)]), userID, 0
+				if row.Title, err = copyName(tx.Model(&Post{}), "title", row.Title); err != nil {
+					return err
+				}
+				if err := tx.Create(&row).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if posts != CopyPostsNone {
+			if err := syncAmounts(tx, affected); err != nil {
+				return err
+			}
+		}
+		return tx.First(&copied, "id = ?", ids[m.ID]).Error
+	})
+	return copied, report, err
+}
+
+// copyName find a value for column of a copy of name that is not taken in query yet,
+// soft deleted rows still hold their unique values
+func copyName(query *gorm.DB, column string, name string) (string, error) {
+	query = query.Session(&gorm.Session{})
+	candidate := name
+	for i := 1; ; i++ {
+		var count int64
+		if err := query.Unscoped().Where(column+" = ?", candidate).Count(&count).Error; err != nil {
+			return candidate, err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = name + " 副本"
+		if i > 1 {
+			candidate += strconv.Itoa(i)
+		}
+	}
+}
+
+// syncAmounts recount the posts of categories ids
+func syncAmounts(tx *gorm.DB, ids []int64) error {
+	return tx.Model(&Category{}).Where("id IN (?)", ids).UpdateColumn("amount",
+		gorm.Expr("(SELECT COUNT(*) FROM posts WHERE posts.category_id = categories.id AND posts.deleted_at IS NULL)")).Error
+}
+
+// LockCategoryTree lock the tree of ownerID until tx ends, a transaction that reads the tree
+// before changing it takes the lock first so it reads the tree as left by the previous writer
+func LockCategoryTree(tx *gorm.DB, ownerID string) error {
+	return nestedset.LockScope(tx, &Category{OwnerID: ownerID})
+}
+
+// FindCategoryRoot find the root of the tree owned by ownerID, creating it on first use
+func FindCategoryRoot(tx *gorm.DB, ownerID string) (Category, error) {
+	root, err := categoryRoot(tx, ownerID)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return root, err
+	}
+	root, err = Category{Name: "根分类", Description: "根分类", OwnerID: ownerID}.Create(tx, nil)
+	if err != nil {
+		// a concurrent request may have created it, the owner and name are unique
+		if found, findErr := categoryRoot(tx, ownerID); findErr == nil {
+			return found, nil
+		}
+	}
+	return root, err
+}
+
+func categoryRoot(tx *gorm.DB, ownerID string) (Category, error) {
+	var one Category
+	err := conn(tx).Where("owner_id = ? AND parent_id IS NULL", ownerID).Order("lft asc").First(&one).Error
+	return one, err
+}
+
+// VerifyCategories check the category tree for drifted lft, rgt, depth and children count
+func VerifyCategories() (nestedset.Report, error) {
+	return nestedset.Verify(db, &Category{})
+}
+
+// RebuildCategories recompute every category tree from parent_id, one tree at a time
+func RebuildCategories() (int, error) {
+	return nestedset.Rebuild(db, &Category{})
 }
 
 func (m Category) Update(values interface{}) (Category, error) {
@@ -58,32 +232,45 @@ func (m Category) Save(cols []string) (Category, error) {
 	return m, nil
 }
 
+// ancestor list ancestors of m below the root of its tree
 func (m Category) ancestor() ([]Category, error) {
 	var rows []Category
-	if err := db.Model(&Category{}).Where("lft < ? AND rgt > ? AND parent_id IS NOT NULL", m.Lft, m.Rgt).Order("lft asc").Find(&rows).Error; err != nil {
+	if err := nestedset.Ancestors(db, m, &rows); err != nil {
 		return rows, err
 	}
+	if len(rows) > 0 && !rows[0].ParentID.Valid {
+		rows = rows[1:]
+	}
 	return rows, nil
 }
 
-func (m Category) descendant() ([]Category, error) {
+// Path list categories from the root of the tree of m down to m
+func (m Category) Path() ([]Category, error) {
 	var rows []Category
-	if err := db.Model(&Category{}).Where("lft > ? AND rgt < ?", m.Lft, m.Rgt).Order("lft asc").Find(&rows).Error; err != nil {
-		return rows, err
-	}
-	descendants := categoryDescendantTree(rows, &m)
-	return descendants, nil
+	err := nestedset.Path(db, m, &rows)
+	return rows, err
 }
 
-func categoryDescendantTree(rows []Category, parent *Category) []Category {
-	next := make([]Category, 0)
-	for i := 0; i < len(rows); i++ {
-		if rows[i].ParentID.Valid && rows[i].ParentID.Int64 == parent.ID {
-			rows[i].Children = categoryDescendantTree(rows, &rows[i])
-			next = append(next, rows[i])
-		}
+// IsDescendantOf tell whether m is below other in the same tree
+func (m Category) IsDescendantOf(other Category) (bool, error) {
+	return nestedset.IsDescendantOf(db, m, other)
+}
+
+// PathIDs list ids from the root down to m, lft and rgt of m must be current within tx
+func (m Category) PathIDs(tx *gorm.DB) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := conn(tx).Model(&Category{}).Where("owner_id = ? AND lft < ? AND rgt > ?", m.OwnerID, m.Lft, m.Rgt).Order("lft asc").Pluck("id", &ids).Error
+	return append(ids, m.ID), err
+}
+
+// descendant nest descendants of m at most depth levels below it, all of them when depth is 0
+func (m Category) descendant(depth int) ([]Category, error) {
+	var rows []Category
+	if err := nestedset.Descendants(db, m, depth, &rows); err != nil {
+		return rows, err
 	}
-	return next
+	err := nestedset.Nest(&rows)
+	return rows, err
 }
 
 func FindCategory(id uint, options map[string]interface{}) (Category, error) {
@@ -94,7 +281,9 @@ func FindCategory(id uint, options map[string]interface{}) (Category, error) {
 	return one, nil
 }
 
-func FindCategoryHierarchy(id uint, options map[string]interface{}) (Category, error) {
+// FindCategoryHierarchy find category id with its ancestors and descendants at most depth levels below it,
+// all of them when depth is 0
+func FindCategoryHierarchy(id uint, depth int, options map[string]interface{}) (Category, error) {
 	one, err := FindCategory(id, options)
 	if err != nil {
 		return one, err
@@ -102,7 +291,7 @@ func FindCategoryHierarchy(id uint, options map[string]interface{}) (Category, e
 	// if !one.ParentID.Valid {
 	// 	return one, errors.New("不可访问根文件夹")
 	// }
-	descendants, err := one.descendant()
+	descendants, err := one.descendant(depth)
 	if err != nil {
 		return one, err
 	}
@@ -144,96 +333,163 @@ func FindAndCountCategories(options map[string]interface{}) ([]Category, int64,
 	return rows, count, nil
 }
 
-func (m Category) Delete() error {
-	tx := db.Begin()
-	if !m.ParentID.Valid {
-		return errors.New("不可删除根文件夹")
-	}
-	err := tx.Model(&m).Association("Posts").Clear()
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	var children []Category
-	err = tx.Model(&Category{}).Where("parent_id = ?", m.ID).Find(&children).Error
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	var root Category
-	err = tx.First(&root, 1).Error
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	for _, child := range children {
-		err := child.MoveTo(&root)
-		if err != nil {
-			tx.Rollback()
+// Strategies for the descendants of a deleted category
+const (
+	DeleteCascade  = "cascade"
+	DeleteToParent = "parent"
+	DeleteToRoot   = "root"
+)
+
+// CategoryDeletion reports what deleting categories did, the fields left out of json
+// tell callers what to announce
+type CategoryDeletion struct {
+	Strategy string  `json:"strategy"`
+	Deleted  []int64 `json:"deleted"`
+	Moved    []int64 `json:"moved"`
+	Posts    int64   `json:"posts"`
+	PostsTo  int64   `json:"postsTo,omitempty"`
+	// Categories are the deleted categories as they were before
+	Categories []Category `json:"-"`
+	// MovedFrom maps the moved children to the parents they had
+	MovedFrom map[int64]int64 `json:"-"`
+	// PostIDs maps the deleted categories to the posts moved out of them
+	PostIDs map[int64][]string `json:"-"`
+}
+
+// Delete delete m as userID, see DeleteCategories
+func (m Category) Delete(tx *gorm.DB, strategy string, postsTo *Category, userID string) (CategoryDeletion, error) {
+	return DeleteCategories(tx, []uint{uint(m.ID)}, strategy, postsTo, userID)
+}
+
+// DeleteCategories delete sibling categories ids with their whole subtree for DeleteCascade, otherwise
+// their children move up to their parent for DeleteToParent or under the root for DeleteToRoot.
+// Posts of the deleted categories move to postsTo, deletion is refused when there are posts
+// but no postsTo, or when some of them are private posts of other users than userID
+func DeleteCategories(tx *gorm.DB, ids []uint, strategy string, postsTo *Category, userID string) (CategoryDeletion, error) {
+	var report CategoryDeletion
+	err := nestedset.Transaction(conn(tx), func(tx *gorm.DB) error {
+		report = CategoryDeletion{
+			Strategy: strategy, Deleted: make([]int64, 0), Moved: make([]int64, 0), Categories: make([]Category, 0),
+			MovedFrom: make(map[int64]int64), PostIDs: make(map[int64][]string),
+		}
+		var rows []Category
+		if err := nestedset.Locking(tx).Where("id IN (?)", ids).Order("lft asc").Find(&rows).Error; err != nil {
 			return err
 		}
-	}
-	err = tx.Delete(&m).Error
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	tx.Commit()
-	return nil
+		owners := make(map[string]bool)
+		for _, row := range rows {
+			if !row.ParentID.Valid {
+				return errors.New("不可删除根文件夹")
+			}
+			if !owners[row.OwnerID] {
+				owners[row.OwnerID] = true
+				if err := LockCategoryTree(tx, row.OwnerID); err != nil {
+					return err
+				}
+			}
+		}
+		if len(rows) > 0 {
+			depth := rows[0].Depth
+			for _, row := range rows {
+				if row.Depth != depth {
+					return errors.New("只能批量删除同级文件夹")
+				}
+			}
+		}
+		for _, row := range rows {
+			report.Deleted = append(report.Deleted, row.ID)
+			report.Categories = append(report.Categories, row)
+			if strategy == DeleteCascade {
+				var descendants []Category
+				if err := nestedset.Descendants(tx, row, 0, &descendants); err != nil {
+					return err
+				}
+				for _, descendant := range descendants {
+					report.Deleted = append(report.Deleted, descendant.ID)
+					report.Categories = append(report.Categories, descendant)
+				}
+			}
+		}
+		if err := moveDeletedPosts(tx, &report, postsTo, userID); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			moved, err := deleteCategory(tx, row, strategy)
+			if err != nil {
+				return err
+			}
+			for _, id := range moved {
+				report.MovedFrom[id] = row.ID
+			}
+			report.Moved = append(report.Moved, moved...)
+		}
+		return nil
+	})
+	return report, err
 }
 
-func DeleteCategories(ids []uint) error {
-	tx := db.Begin()
-	for _, id := range ids {
-		if id == 1 {
-			return errors.New("不可删除根文件夹")
-		}
-	}
-	var rows []Category
-	err := tx.Where("id IN (?)", ids).Find(&rows).Error
-	if err != nil {
-		tx.Rollback()
+// moveDeletedPosts move posts of the categories deleted by report to postsTo, private posts
+// of other users than userID are not theirs to move
+func moveDeletedPosts(tx *gorm.DB, report *CategoryDeletion, postsTo *Category, userID string) error {
+	query := tx.Model(&Post{}).Where("category_id IN (?)", report.Deleted)
+	var posts []Post
+	if err := nestedset.Locking(query.Session(&gorm.Session{})).Select("id", "category_id", "user_id", "is_public").Find(&posts).Error; err != nil {
 		return err
 	}
-	if len(rows) > 0 {
-		depth := rows[0].Depth
-		for _, row := range rows {
-			if row.Depth != depth {
-				return errors.New("只能批量删除同级文件夹")
-			}
+	report.Posts = int64(len(posts))
+	if report.Posts == 0 {
+		return nil
+	}
+	for _, post := range posts {
+		if !post.IsPublic && post.UserID != userID {
+			return errors.New("分类下存在其他用户的私有文章，不可删除")
 		}
 	}
-	err = tx.Model(&rows).Association("Posts").Clear()
-	if err != nil {
-		tx.Rollback()
-		return err
+	if postsTo == nil {
+		return errors.New("分类下存在文章，请指定文章移至的分类")
 	}
-	var root Category
-	err = tx.First(&root, 1).Error
-	if err != nil {
-		tx.Rollback()
-		return err
+	for _, id := range report.Deleted {
+		if id == postsTo.ID {
+			return errors.New("不可将文章移至被删除的分类")
+		}
 	}
-	var children []Category
-	err = tx.Model(&Category{}).Where("parent_id IN (?)", ids).Find(&children).Error
-	if err != nil {
-		tx.Rollback()
+	if err := query.Session(&gorm.Session{}).Update("category_id", postsTo.ID).Error; err != nil {
 		return err
 	}
-	for _, child := range children {
-		err := child.MoveTo(&root)
+	for _, post := range posts {
+		from := int64(post.CategoryID)
+		report.PostIDs[from] = append(report.PostIDs[from], post.ID)
+	}
+	report.PostsTo = postsTo.ID
+	return syncAmounts(tx, append([]int64{postsTo.ID}, report.Deleted...))
+}
+
+// deleteCategory delete m following strategy and return the ids of the children it moved
+func deleteCategory(tx *gorm.DB, m Category, strategy string) ([]int64, error) {
+	moved := make([]int64, 0)
+	if strategy != DeleteCascade {
+		var children []Category
+		if err := tx.Where("parent_id = ?", m.ID).Order("lft asc").Find(&children).Error; err != nil {
+			return moved, err
+		}
+		root, err := categoryRoot(tx, m.OwnerID)
 		if err != nil {
-			tx.Rollback()
-			return err
+			return moved, err
+		}
+		// children are placed before m or as first children of root one by one,
+		// so the last goes first when they move under root to keep their order
+		for i := range children {
+			child, to, direction := children[i], m, nestedset.MoveDirectionLeft
+			if strategy == DeleteToRoot {
+				child, to, direction = children[len(children)-1-i], root, nestedset.MoveDirectionInner
+			}
+			if err := child.MoveTo(tx, &to, direction); err != nil {
+				return moved, err
+			}
+			moved = append(moved, child.ID)
 		}
 	}
-	err = tx.Delete(&rows).Error
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	tx.Commit()
-	return nil
+	return moved, nestedset.Delete(tx, &m)
 }
 
 func (m Category) Relations(col string) *gorm.Association {
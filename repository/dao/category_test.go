@@ -0,0 +1,179 @@
+package dao
+
+import (
+	"app/lib/nestedset"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// treeShape the position of every category keyed by id
+type treeShape map[int64][4]int64
+
+func snapshotCategories(t *testing.T, tx *gorm.DB) treeShape {
+	t.Helper()
+	var rows []Category
+	if err := conn(tx).Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	shape := make(treeShape, len(rows))
+	for _, row := range rows {
+		shape[row.ID] = [4]int64{int64(row.Lft), int64(row.Rgt), int64(row.Depth), row.ParentID.Int64}
+	}
+	return shape
+}
+
+func TestTransactionRollsBackMoves(t *testing.T) {
+	setupDB(t)
+	root, err := FindCategoryRoot(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := createCategories(t, root, "a", "b", "c")
+	createCategories(t, rows[0], "a1", "a2")
+	before := snapshotCategories(t, nil)
+
+	failed := errors.New("abort")
+	err = Transaction(func(tx *gorm.DB) error {
+		if err := rows[0].MoveTo(tx, &rows[2], nestedset.MoveDirectionInner); err != nil {
+			return err
+		}
+		b, err := rows[1].Reload(tx)
+		if err != nil {
+			return err
+		}
+		c, err := rows[2].Reload(tx)
+		if err != nil {
+			return err
+		}
+		if err := b.MoveTo(tx, &c, nestedset.MoveDirectionRight); err != nil {
+			return err
+		}
+		if moved := snapshotCategories(t, tx); reflect.DeepEqual(before, moved) {
+			t.Error("moves did not change the tree inside the transaction")
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected the transaction to fail with its own error, got %v", err)
+	}
+	if after := snapshotCategories(t, nil); !reflect.DeepEqual(before, after) {
+		t.Fatalf("tree changed by a rolled back transaction\nbefore: %v\nafter:  %v", before, after)
+	}
+	report, err := VerifyCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("tree is invalid: %+v", report)
+	}
+}
+
+func TestMovesStayInsideTheirTree(t *testing.T) {
+	setupDB(t)
+	mine, err := FindCategoryRoot(nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := FindCategoryRoot(nil, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := createCategories(t, mine, "a", "b", "c")
+	others := createCategories(t, theirs, "a", "b")
+	createCategories(t, others[0], "a1")
+
+	shapeOf := func(ownerID string) treeShape {
+		shape := treeShape{}
+		for id, pos := range snapshotCategories(t, nil) {
+			var row Category
+			if err := db.First(&row, id).Error; err != nil {
+				t.Fatal(err)
+			}
+			if row.OwnerID == ownerID {
+				shape[id] = pos
+			}
+		}
+		return shape
+	}
+	before := shapeOf("bob")
+
+	moves := []struct {
+		node, to  int
+		direction nestedset.MoveDirection
+	}{
+		{0, 2, nestedset.MoveDirectionInner},
+		{1, 2, nestedset.MoveDirectionLeft},
+		{2, 1, nestedset.MoveDirectionRight},
+	}
+	for _, move := range moves {
+		node, err := rows[move.node].Reload(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := rows[move.to].Reload(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.MoveTo(nil, &to, move.direction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rows[0].MoveTo(nil, &others[1], nestedset.MoveDirectionInner); err == nil {
+		t.Fatal("expected a move into another tree to be rejected")
+	}
+	if _, err := rows[1].Delete(nil, DeleteCascade, nil, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := shapeOf("bob"); !reflect.DeepEqual(before, after) {
+		t.Fatalf("writes to one tree changed another\nbefore: %v\nafter:  %v", before, after)
+	}
+	report, err := VerifyCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("tree is invalid: %+v", report)
+	}
+}
+
+func TestRebuildAdoptsOrphans(t *testing.T) {
+	setupDB(t)
+	root, err := FindCategoryRoot(nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := createCategories(t, root, "a", "b")
+	children := createCategories(t, rows[0], "a1", "a2")
+	createCategories(t, children[0], "a11")
+	// the parent vanishes behind the back of the tree, its children keep pointing at it
+	if err := db.Exec("DELETE FROM categories WHERE id = ?", rows[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RebuildCategories(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("tree is invalid after rebuild: %+v", report)
+	}
+	for _, child := range children {
+		adopted, err := child.Reload(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if adopted.ParentID.Int64 != root.ID || adopted.Depth != 1 {
+			t.Fatalf("orphan %s has parent %d and depth %d, expected to move under the root", adopted.Name, adopted.ParentID.Int64, adopted.Depth)
+		}
+	}
+	if updated, err := RebuildCategories(); err != nil || updated != 0 {
+		t.Fatalf("expected a second rebuild to change nothing, updated %d: %v", updated, err)
+	}
+}
@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"app/lib/nestedset"
 	"app/util"
 	"log"
 
@@ -12,15 +13,36 @@ import (
 var db *gorm.DB
 
 func Init(dsn string) {
-	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
+	InitWith(mysql.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
+}
+
+// InitWith open the database through dialector instead of mysql, e.g. sqlite in tests, and migrate it
+func InitWith(dialector gorm.Dialector, config *gorm.Config) {
+	var err error
+	db, err = gorm.Open(dialector, config)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// db.Debug().Logger
-	db.AutoMigrate(&User{}, &Post{}, &Category{})
+	// accounts created before emails were verified have no email_verified_at yet
+	backfillVerified := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "EmailVerifiedAt")
+	db.AutoMigrate(&User{}, &Post{}, &Category{}, &RefreshToken{}, &Role{}, &Permission{}, &UserToken{}, &RecoveryCode{}, &APIKey{}, &Session{}, &UserIdentity{}, &Notification{})
+	// active ones keep logging in, their email counts as verified since they registered
+	if backfillVerified {
+		err := db.Model(&User{}).Where("is_actived = ?", true).
+			UpdateColumn("email_verified_at", gorm.Expr("created_at")).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// category names used to be unique across the single tree, they are unique per owner now
+	if db.Migrator().HasIndex(&Category{}, "idx_categories_name") {
+		if err := db.Migrator().DropIndex(&Category{}, "idx_categories_name"); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func Close() error {
@@ -31,6 +53,20 @@ func Close() error {
 	return d.Close()
 }
 
+// Transaction run fn in a database transaction, dao methods taking a tx join it.
+// It is tried again when it loses a deadlock, see nestedset.Transaction
+func Transaction(fn func(tx *gorm.DB) error) error {
+	return nestedset.Transaction(db, fn)
+}
+
+// conn pick tx when a caller passes its transaction, the default connection otherwise
+func conn(tx *gorm.DB) *gorm.DB {
+	if tx == nil {
+		return db
+	}
+	return tx
+}
+
 type BaseModel struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
 	CreatedAt util.LocalTime `json:"createdAt"`
@@ -100,7 +136,7 @@ func initData() {
 	var category = Category{
 		Name: "根分类", Description: "根分类", Lft: 1, Rgt: 2, Depth: 0,
 	}
-	_, err := category.Create(nil)
+	_, err := category.Create(nil, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,32 @@
+package dao
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupDB point the package at a fresh sqlite file
+func setupDB(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	InitWith(sqlite.Open(path+"?_txlock=immediate&_busy_timeout=30000"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	t.Cleanup(func() { _ = Close() })
+}
+
+// createCategories create a category below parent for every name, in order
+func createCategories(t *testing.T, parent Category, names ...string) []Category {
+	t.Helper()
+	rows := make([]Category, 0, len(names))
+	for _, name := range names {
+		created, err := Category{Name: name, OwnerID: parent.OwnerID}.Create(nil, &parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, created)
+	}
+	return rows
+}
@@ -0,0 +1,87 @@
+package dao
+
+import (
+	"app/util"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Notification is a message addressed to a user, it is kept until read so that
+// users who were offline get it once they connect again
+type Notification struct {
+	BaseModel
+	ID          string          `gorm:"size:100;not null;primaryKey" json:"id"`
+	UserID      string          `gorm:"size:100;index;not null" json:"userID"`
+	Type        string          `gorm:"size:100;not null" json:"type"`
+	Content     string          `gorm:"type:text" json:"content"`
+	DeliveredAt *util.LocalTime `json:"deliveredAt"`
+	ReadAt      *util.LocalTime `gorm:"index" json:"readAt"`
+}
+
+func (m Notification) Create() (Notification, error) {
+	m.ID = uuid.NewV4().String()
+	if err := db.Create(&m).Error; err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func FindAndCountNotifications(options map[string]interface{}) ([]Notification, int64, error) {
+	var rows []Notification
+	var count int64
+	if err := db.Scopes(applyQueryOptions(options)).Find(&rows).Error; err != nil {
+		return rows, count, err
+	}
+	delete(options, "offset")
+	delete(options, "limit")
+	delete(options, "order")
+	if err := db.Model(&Notification{}).Scopes(applyQueryOptions(options)).Count(&count).Error; err != nil {
+		return rows, count, err
+	}
+	return rows, count, nil
+}
+
+// CountUnreadNotifications count notifications of userID not read yet
+func CountUnreadNotifications(userID string) (int64, error) {
+	var count int64
+	err := db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// TakePendingNotifications mark notifications of userID which never reached a client
+// as delivered and return them, oldest first. Rows claimed by a concurrent call are skipped.
+func TakePendingNotifications(userID string, limit int) ([]Notification, error) {
+	var rows []Notification
+	err := db.Where("user_id = ? AND delivered_at IS NULL AND read_at IS NULL", userID).
+		Order("created_at asc").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return rows, err
+	}
+	taken := make([]Notification, 0, len(rows))
+	now := util.LocalTime{Time: time.Now()}
+	for _, row := range rows {
+		rst := db.Model(&Notification{}).Where("id = ? AND delivered_at IS NULL", row.ID).Update("delivered_at", now.Time)
+		if rst.Error != nil {
+			return taken, rst.Error
+		}
+		if rst.RowsAffected > 0 {
+			row.DeliveredAt = &now
+			taken = append(taken, row)
+		}
+	}
+	return taken, nil
+}
+
+func MarkNotificationsDelivered(ids []string) error {
+	return db.Model(&Notification{}).Where("id IN (?) AND delivered_at IS NULL", ids).Update("delivered_at", time.Now()).Error
+}
+
+// ReadNotifications mark notifications of userID as read, every unread one when ids is empty
+func ReadNotifications(userID string, ids []string) error {
+	tx := db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if len(ids) > 0 {
+		tx = tx.Where("id IN (?)", ids)
+	}
+	return tx.Update("read_at", time.Now()).Error
+}
@@ -0,0 +1,41 @@
+package dao
+
+import (
+	"app/util"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code replacing a totp code when the authenticator is lost
+type RecoveryCode struct {
+	BaseModel
+	UserID   string          `gorm:"size:100;index;not null" json:"userID"`
+	CodeHash string          `gorm:"size:64;not null" json:"-"`
+	UsedAt   *util.LocalTime `json:"usedAt"`
+}
+
+// ReplaceRecoveryCodes drop all recovery codes of user and store codes instead
+func ReplaceRecoveryCodes(userID string, codes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		rows := make([]RecoveryCode, 0, len(codes))
+		for _, code := range codes {
+			rows = append(rows, RecoveryCode{UserID: userID, CodeHash: util.HashToken(code)})
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// UseRecoveryCode burn code of user, reports false when it doesn't exist or was used
+func UseRecoveryCode(userID string, code string) (bool, error) {
+	rst := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, util.HashToken(code)).
+		Update("used_at", time.Now())
+	return rst.RowsAffected > 0, rst.Error
+}
@@ -0,0 +1,145 @@
+package dao
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Permissions checked by middleware.Permission
+const (
+	PermissionUserUpdate        = "user:update"
+	PermissionUserDelete        = "user:delete"
+	PermissionUserActive        = "user:active"
+	PermissionUserResetPassword = "user:reset-password"
+	PermissionCategoryDelete    = "category:delete"
+	PermissionCategoryManage    = "category:manage"
+	PermissionRoleManage        = "role:manage"
+	PermissionSessionManage     = "session:manage"
+)
+
+var Permissions = []string{
+	PermissionUserUpdate, PermissionUserDelete, PermissionUserActive,
+	PermissionUserResetPassword, PermissionCategoryDelete, PermissionCategoryManage,
+	PermissionRoleManage, PermissionSessionManage,
+}
+
+// Scopes API keys may carry besides Permissions, they are not granted by roles but narrow
+// which of the routes open to every user a key may call, checked by middleware.Scope
+const (
+	ScopeUserRead      = "user:read"
+	ScopePostWrite     = "post:write"
+	ScopeCategoryWrite = "category:write"
+	ScopeEventRead     = "event:read"
+)
+
+var Scopes = []string{ScopeUserRead, ScopePostWrite, ScopeCategoryWrite, ScopeEventRead}
+
+type Role struct {
+	BaseModel
+	Name        string       `gorm:"size:100;uniqueIndex;not null" json:"name"`
+	Description string       `gorm:"type:text" json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions" binding:"-" json:"permissions"`
+}
+
+type Permission struct {
+	BaseModel
+	Name        string `gorm:"size:100;uniqueIndex;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+}
+
+// InitRoles make sure all permissions, the admin role and the default role exist,
+// admin role always owns every permission
+func InitRoles(adminRole string, defaultRole string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		perms := make([]Permission, 0)
+		for _, name := range Permissions {
+			var one Permission
+			if err := tx.Where(Permission{Name: name}).FirstOrCreate(&one).Error; err != nil {
+				return err
+			}
+			perms = append(perms, one)
+		}
+		var admin Role
+		if err := tx.Where(Role{Name: adminRole}).FirstOrCreate(&admin).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&admin).Association("Permissions").Replace(perms); err != nil {
+			return err
+		}
+		var role Role
+		return tx.Where(Role{Name: defaultRole}).FirstOrCreate(&role).Error
+	})
+}
+
+func (m Role) Create() (Role, error) {
+	if err := db.Create(&m).Error; err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (m Role) Update(values interface{}) (Role, error) {
+	err := db.Model(&m).Updates(values).Error
+	return m, err
+}
+
+func (m Role) Relations(col string) *gorm.Association {
+	return db.Model(&m).Association(col)
+}
+
+func (m Role) Delete() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&m).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM user_roles WHERE role_id = ?", m.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&m).Error
+	})
+}
+
+func FindRole(id uint, options map[string]interface{}) (Role, error) {
+	var one Role
+	if err := db.Scopes(applyQueryOptions(options)).First(&one, "id = ?", id).Error; err != nil {
+		return one, err
+	}
+	return one, nil
+}
+
+func FindRoleByName(name string) (bool, Role) {
+	var one Role
+	err := db.Where("name = ?", name).First(&one).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	return !notFound, one
+}
+
+func FindRoles(options map[string]interface{}) ([]Role, error) {
+	var rows []Role
+	if err := db.Scopes(applyQueryOptions(options)).Find(&rows).Error; err != nil {
+		return rows, err
+	}
+	return rows, nil
+}
+
+func FindPermissions(options map[string]interface{}) ([]Permission, error) {
+	var rows []Permission
+	if err := db.Scopes(applyQueryOptions(options)).Find(&rows).Error; err != nil {
+		return rows, err
+	}
+	return rows, nil
+}
+
+// FindPermissionNames list distinct permission names granted to any of roles
+func FindPermissionNames(roles []string) ([]string, error) {
+	names := make([]string, 0)
+	if len(roles) == 0 {
+		return names, nil
+	}
+	err := db.Model(&Permission{}).Distinct("permissions.name").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN roles ON roles.id = role_permissions.role_id AND roles.deleted_at IS NULL").
+		Where("roles.name IN (?)", roles).Pluck("permissions.name", &names).Error
+	return names, err
+}
@@ -0,0 +1,114 @@
+package dao
+
+import (
+	"app/util"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Session is created by every login, access tokens carry its ID as sid
+// and its refresh tokens use its ID as family
+type Session struct {
+	BaseModel
+	ID         string          `gorm:"size:100;not null;primaryKey" json:"id"`
+	UserID     string          `gorm:"size:100;index;not null" json:"userID"`
+	Device     string          `gorm:"size:200" json:"device"`
+	UserAgent  string          `gorm:"type:text" json:"userAgent"`
+	IP         string          `gorm:"size:64" json:"ip"`
+	MFA        bool            `gorm:"type:boolean;default:false" json:"mfa"`
+	LastSeenAt util.LocalTime  `json:"lastSeenAt"`
+	RevokedAt  *util.LocalTime `json:"revokedAt"`
+}
+
+func (m Session) Create() (Session, error) {
+	m.ID = uuid.NewV4().String()
+	m.LastSeenAt = util.LocalTime{Time: time.Now()}
+	if err := db.Create(&m).Error; err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (m Session) IsRevoked() bool {
+	return m.RevokedAt != nil
+}
+
+// Touch refresh last seen time and ip, writes at most once per minute unless ip changes
+func (m Session) Touch(ip string) error {
+	if m.IP == ip && time.Since(m.LastSeenAt.Time) < time.Minute {
+		return nil
+	}
+	return db.Model(&Session{}).Where("id = ?", m.ID).Updates(map[string]interface{}{
+		"last_seen_at": time.Now(), "ip": ip,
+	}).Error
+}
+
+func (m Session) Revoke() error {
+	return RevokeSession(m.ID)
+}
+
+// RevokeSession end session id and revoke its refresh token family
+func RevokeSession(id string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).Where("family = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now()).Error
+	})
+}
+
+func FindSession(id string) (bool, Session) {
+	var one Session
+	err := db.Where("id = ?", id).First(&one).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	return !notFound, one
+}
+
+func FindSessions(options map[string]interface{}) ([]Session, error) {
+	var rows []Session
+	if err := db.Scopes(applyQueryOptions(options)).Find(&rows).Error; err != nil {
+		return rows, err
+	}
+	return rows, nil
+}
+
+// RevokeUserSessions end every session and revoke every refresh token of users
+func RevokeUserSessions(userIDs []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return revokeUserSessions(tx, userIDs)
+	})
+}
+
+// RevokeOtherSessions end every session of userID but keep and revoke their refresh tokens,
+// returns the ids of the ended sessions
+func RevokeOtherSessions(userID string, keep string) ([]string, error) {
+	ids := make([]string, 0)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			if err := tx.Model(&Session{}).Where("id IN (?)", ids).Update("revoked_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&RefreshToken{}).Where("user_id = ? AND family <> ? AND revoked_at IS NULL", userID, keep).
+			Update("revoked_at", time.Now()).Error
+	})
+	return ids, err
+}
+
+func revokeUserSessions(tx *gorm.DB, userIDs []string) error {
+	err := tx.Model(&Session{}).Where("user_id IN (?) AND revoked_at IS NULL", userIDs).Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&RefreshToken{}).Where("user_id IN (?) AND revoked_at IS NULL", userIDs).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"app/util"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a rotating refresh token, every refresh revokes the current
+// token and issues a new one in the same family. Only the hash is persisted.
+type RefreshToken struct {
+	BaseModel
+	ID        string          `gorm:"size:100;not null;primaryKey" json:"id"`
+	UserID    string          `gorm:"size:100;index;not null" json:"userID"`
+	Family    string          `gorm:"size:100;index;not null" json:"family"`
+	TokenHash string          `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiredAt util.LocalTime  `json:"expiredAt"`
+	RevokedAt *util.LocalTime `json:"revokedAt"`
+	MFA       bool            `gorm:"type:boolean;default:false" json:"mfa"`
+}
+
+func (m RefreshToken) IsExpired() bool {
+	return m.ExpiredAt.Before(time.Now())
+}
+
+func (m RefreshToken) IsRevoked() bool {
+	return m.RevokedAt != nil
+}
+
+// IssueRefreshToken create a refresh token for user, a new family is started when family is empty,
+// mfa tells whether the login passed a second factor
+func IssueRefreshToken(tx *gorm.DB, userID string, family string, mfa bool, ttl time.Duration) (string, RefreshToken, error) {
+	m := RefreshToken{UserID: userID, Family: family, MFA: mfa}
+	token, err := util.RandomToken(32)
+	if err != nil {
+		return token, m, err
+	}
+	m.ID = uuid.NewV4().String()
+	if m.Family == "" {
+		m.Family = uuid.NewV4().String()
+	}
+	m.TokenHash = util.HashToken(token)
+	m.ExpiredAt = util.LocalTime{Time: time.Now().Add(ttl)}
+	if tx == nil {
+		tx = db
+	}
+	if err := tx.Create(&m).Error; err != nil {
+		return token, m, err
+	}
+	return token, m, nil
+}
+
+func FindRefreshToken(token string) (bool, RefreshToken) {
+	var one RefreshToken
+	err := db.Where("token_hash = ?", util.HashToken(token)).First(&one).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	return !notFound, one
+}
+
+// Rotate revoke current token and issue its successor within one transaction,
+// fails if the token has been used concurrently
+func (m RefreshToken) Rotate(ttl time.Duration) (string, RefreshToken, error) {
+	var token string
+	var next RefreshToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		rst := tx.Model(&RefreshToken{}).Where("id = ? AND revoked_at IS NULL", m.ID).
+			Update("revoked_at", time.Now())
+		if rst.Error != nil {
+			return rst.Error
+		}
+		if rst.RowsAffected == 0 {
+			return errors.New("刷新令牌已失效")
+		}
+		var err error
+		token, next, err = IssueRefreshToken(tx, m.UserID, m.Family, m.MFA, ttl)
+		return err
+	})
+	return token, next, err
+}
@@ -12,17 +12,27 @@ import (
 
 type User struct {
 	BaseModel
-	ID            string         `gorm:"size:100;not_null;primary_key" json:"id"`
-	Username      string         `gorm:"size:100;unique_index;not_null" json:"username"`
-	Password      string         `gorm:"size:200,not_null" json:"-"`
-	Email         string         `gorm:"size:200" json:"email"`
-	Avatar        string         `gorm:"type:text" json:"avatar"`
-	Memo          string         `gorm:"type:text" json:"memo"`
-	IsActived     bool           `gorm:"type:boolean;default:true" binding:"-" json:"isActived"`
-	LastLoginedAt util.LocalTime `json:"lastLoginedAt"`
+	ID              string          `gorm:"size:100;not_null;primary_key" json:"id"`
+	Username        string          `gorm:"size:100;unique_index;not_null" json:"username"`
+	Password        string          `gorm:"size:200,not_null" json:"-"`
+	Email           string          `gorm:"size:200" json:"email"`
+	Avatar          string          `gorm:"type:text" json:"avatar"`
+	Memo            string          `gorm:"type:text" json:"memo"`
+	IsActived       bool            `gorm:"type:boolean;default:false" binding:"-" json:"isActived"`
+	LastLoginedAt   util.LocalTime  `json:"lastLoginedAt"`
+	EmailVerifiedAt *util.LocalTime `json:"emailVerifiedAt"`
+	TOTPSecret      string          `gorm:"size:100" json:"-"`
+	TOTPEnabled     bool            `gorm:"type:boolean;default:false" binding:"-" json:"totpEnabled"`
+	TOTPLastStep    int64           `gorm:"default:0" json:"-"`
+	Roles           []Role          `gorm:"many2many:user_roles" binding:"-" json:"roles,omitempty"`
 }
 
-func (m User) Create() (User, error) {
+// Create create user within tx
+func (m User) Create(tx *gorm.DB) (User, error) {
+	return m.create(conn(tx))
+}
+
+func (m User) create(tx *gorm.DB) (User, error) {
 	id := uuid.NewV4().String()
 	m.ID = id
 	m.LastLoginedAt = util.LocalTime{Time: time.Now()}
@@ -31,7 +41,7 @@ func (m User) Create() (User, error) {
 		return m, err
 	}
 	m.Password = string(hashedPassword)
-	if err := db.Create(&m).Error; err != nil {
+	if err := tx.Create(&m).Error; err != nil {
 		return m, err
 	}
 	return m, nil
@@ -66,6 +76,13 @@ func FindByUsername(username string) (bool, User) {
 	return !notFound, one
 }
 
+func FindByEmail(email string) (bool, User) {
+	var one User
+	err := db.Where("email = ?", email).First(&one).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	return !notFound, one
+}
+
 func FindByUsernameOrEmail(usernameOrEmail string) (bool, User) {
 	var one User
 	err := db.Where("username = ?", usernameOrEmail).Or("email = ?", usernameOrEmail).First(&one).Error
@@ -78,7 +95,12 @@ func DeleteUser(id string) (User, error) {
 	if err := db.Find(&one, "id = ?", id).Error; err != nil {
 		return one, err
 	}
-	err := db.Delete(&one).Error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&one).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, []string{one.ID})
+	})
 	return one, err
 }
 
@@ -117,3 +139,21 @@ func FindUser(id string, options map[string]interface{}) (User, error) {
 func (user User) Relations(col string) *gorm.Association {
 	return db.Model(&user).Association(col)
 }
+
+// RoleNames list names of roles assigned to user
+func (m User) RoleNames() ([]string, error) {
+	names := make([]string, 0)
+	err := db.Model(&Role{}).Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", m.ID).Pluck("roles.name", &names).Error
+	return names, err
+}
+
+func (m User) AssignRoles(roles []Role) error {
+	return db.Model(&m).Association("Roles").Replace(roles)
+}
+
+// UseTOTPStep record step as the last accepted totp step, fails if it or a later step was used
+func (m User) UseTOTPStep(step int64) (bool, error) {
+	rst := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", m.ID, step).Update("totp_last_step", step)
+	return rst.RowsAffected > 0, rst.Error
+}
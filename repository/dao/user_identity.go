@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentity links an account of an external identity provider to a user
+type UserIdentity struct {
+	BaseModel
+	UserID   string `gorm:"size:100;index;not null" json:"userID"`
+	Provider string `gorm:"size:50;not null;uniqueIndex:idx_provider_subject" json:"provider"`
+	Subject  string `gorm:"size:200;not null;uniqueIndex:idx_provider_subject" json:"subject"`
+	Email    string `gorm:"size:200" json:"email"`
+	User     *User  `binding:"-" json:"user,omitempty"`
+}
+
+func (m UserIdentity) Create() (UserIdentity, error) {
+	err := db.Create(&m).Error
+	return m, err
+}
+
+// FindUserIdentity find the identity with its user by provider and subject
+func FindUserIdentity(provider string, subject string) (bool, UserIdentity) {
+	var one UserIdentity
+	err := db.Preload("User").Where("provider = ? AND subject = ?", provider, subject).First(&one).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	return !notFound, one
+}
+
+// CreateUserWithIdentity create user together with the identity it signed up through
+func CreateUserWithIdentity(user User, identity UserIdentity) (User, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		created, err := user.create(tx)
+		if err != nil {
+			return err
+		}
+		user = created
+		identity.UserID = user.ID
+		return tx.Create(&identity).Error
+	})
+	return user, err
+}
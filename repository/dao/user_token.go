@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"app/util"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// UserToken records one-time tokens sent to users like email verification
+// and password reset, the signed token carries ID as its jti
+type UserToken struct {
+	BaseModel
+	ID        string          `gorm:"size:100;not null;primaryKey" json:"id"`
+	UserID    string          `gorm:"size:100;index;not null" json:"userID"`
+	Purpose   string          `gorm:"size:50;not null" json:"purpose"`
+	ExpiredAt util.LocalTime  `json:"expiredAt"`
+	UsedAt    *util.LocalTime `json:"usedAt"`
+}
+
+// IssueUserToken create a token of purpose for user within tx, unused tokens of the same purpose are invalidated
+func IssueUserToken(tx *gorm.DB, userID string, purpose string, ttl time.Duration) (UserToken, error) {
+	m := UserToken{
+		ID: uuid.NewV4().String(), UserID: userID, Purpose: purpose,
+		ExpiredAt: util.LocalTime{Time: time.Now().Add(ttl)},
+	}
+	err := conn(tx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&m).Error
+	})
+	return m, err
+}
+
+// ConsumeUserToken mark token as used, fails when it is unknown, expired or already used
+func ConsumeUserToken(id string, purpose string) (UserToken, error) {
+	var one UserToken
+	err := db.Where("id = ? AND purpose = ?", id, purpose).First(&one).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return one, errors.New("令牌无效")
+		}
+		return one, err
+	}
+	if one.ExpiredAt.Before(time.Now()) {
+		return one, errors.New("令牌已过期")
+	}
+	rst := db.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", one.ID).Update("used_at", time.Now())
+	if rst.Error != nil {
+		return one, rst.Error
+	}
+	if rst.RowsAffected == 0 {
+		return one, errors.New("令牌已被使用")
+	}
+	return one, nil
+}
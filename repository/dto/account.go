@@ -0,0 +1,140 @@
+package dto
+
+import (
+	"app/lib/mailer"
+	"app/repository/dao"
+	"app/util"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	PurposeVerifyEmail   = "verify-email"
+	PurposeResetPassword = "reset-password"
+
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// sendUserToken issue a one-time token of purpose within tx and mail it signed to user,
+// link is a url template whose {token} placeholder is replaced by the token
+func sendUserToken(tx *gorm.DB, user dao.User, purpose string, ttl time.Duration, link string, subject string, content string) error {
+	issued, err := dao.IssueUserToken(tx, user.ID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	token, err := util.GeneratePurposeToken(purpose, map[string]interface{}{
+		"jti": issued.ID, "sub": user.ID,
+	}, ttl)
+	if err != nil {
+		return err
+	}
+	link = strings.ReplaceAll(link, "{token}", url.QueryEscape(token))
+	return mailer.Send(user.Email, subject, fmt.Sprintf(content, user.Username, link, ttl))
+}
+
+// consumeUserToken verify signature of token and burn it, returns the user it was issued to
+func consumeUserToken(token string, purpose string) (dao.User, error) {
+	var user dao.User
+	claims, err := util.DecodePurposeToken(token, purpose)
+	if err != nil {
+		return user, errors.New("令牌无效")
+	}
+	jti, _ := claims["jti"].(string)
+	consumed, err := dao.ConsumeUserToken(jti, purpose)
+	if err != nil {
+		return user, err
+	}
+	user, err = dao.FindUser(consumed.UserID, nil)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, errors.New("用户不存在")
+		} else {
+			return user, err
+		}
+	}
+	return user, nil
+}
+
+// SendVerification mail a link verifying the email of user, the token is issued within tx
+func SendVerification(tx *gorm.DB, user dao.User, link string) error {
+	return sendUserToken(tx, user, PurposeVerifyEmail, verifyEmailTTL, link, "验证邮箱",
+		"%s，您好：\n\n请打开以下链接完成邮箱验证：\n%s\n\n链接有效期为 %s。\n")
+}
+
+type VerifyEmail struct {
+	Token string `binding:"required" json:"token"`
+}
+
+// Verify mark email as verified, which logging in with a password requires, activation is left to ToggleUserActive
+func (body *VerifyEmail) Verify() (dao.User, error) {
+	user, err := consumeUserToken(body.Token, PurposeVerifyEmail)
+	if err != nil {
+		return user, err
+	}
+	if user.EmailVerifiedAt != nil {
+		return user, errors.New("邮箱已验证")
+	}
+	return user.Update(map[string]interface{}{
+		"email_verified_at": time.Now(),
+	})
+}
+
+type ResendVerification struct {
+	Email string `binding:"required,lt=200,email" json:"email"`
+}
+
+// Resend always succeeds for unknown or verified emails so that accounts can't be enumerated
+func (body *ResendVerification) Resend(link string) error {
+	exists, user := dao.FindByEmail(body.Email)
+	if !exists || user.EmailVerifiedAt != nil {
+		return nil
+	}
+	return SendVerification(nil, user, link)
+}
+
+type ForgotPassword struct {
+	Email string `binding:"required,lt=200,email" json:"email"`
+}
+
+// Forgot mail a reset link, unknown emails are silently ignored
+func (body *ForgotPassword) Forgot(link string) error {
+	exists, user := dao.FindByEmail(body.Email)
+	if !exists || !user.IsActived {
+		return nil
+	}
+	return sendUserToken(nil, user, PurposeResetPassword, resetPasswordTTL, link, "重置密码",
+		"%s，您好：\n\n请打开以下链接重置密码：\n%s\n\n链接有效期为 %s，如非本人操作请忽略此邮件。\n")
+}
+
+type ResetPasswordByToken struct {
+	Token          string `binding:"required" json:"token"`
+	NewPassword    string `binding:"required,lt=200" json:"newPassword"`
+	RepeatPassword string `binding:"required,lt=200" json:"repeatPassword"`
+}
+
+// Reset set a new password and revoke all refresh tokens of the user
+func (body *ResetPasswordByToken) Reset() (dao.User, error) {
+	var user dao.User
+	if body.NewPassword != body.RepeatPassword {
+		return user, errors.New("重复密码不匹配")
+	}
+	user, err := consumeUserToken(body.Token, PurposeResetPassword)
+	if err != nil {
+		return user, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), 4)
+	if err != nil {
+		return user, err
+	}
+	if err := dao.RevokeUserSessions([]string{user.ID}); err != nil {
+		return user, err
+	}
+	return user.Update(map[string]interface{}{"password": string(hashedPassword)})
+}
@@ -0,0 +1,77 @@
+package dto
+
+import (
+	"app/repository/dao"
+	"app/util"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NewAPIKey struct {
+	Name      string          `binding:"required,lt=100" json:"name"`
+	Scopes    string          `binding:"omitempty" json:"scopes"`
+	ExpiredAt *util.LocalTime `binding:"omitempty" json:"expiredAt"`
+}
+
+// Create issue a key for user, the plain key is only returned by this call
+func (body *NewAPIKey) Create(userID string) (dao.APIKey, string, error) {
+	m := dao.APIKey{
+		UserID: userID, Name: body.Name, ExpiredAt: body.ExpiredAt,
+	}
+	if body.ExpiredAt != nil && body.ExpiredAt.Before(time.Now()) {
+		return m, "", errors.New("过期时间不能早于当前时间")
+	}
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(body.Scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !isPermission(scope) && !isScope(scope) {
+			return m, "", errors.New("权限不存在")
+		}
+		scopes = append(scopes, scope)
+	}
+	m.Scopes = strings.Join(scopes, ",")
+	return m.Create()
+}
+
+func isScope(name string) bool {
+	for _, scope := range dao.Scopes {
+		if scope == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isPermission(name string) bool {
+	for _, perm := range dao.Permissions {
+		if perm == name {
+			return true
+		}
+	}
+	return false
+}
+
+func FindAPIKeys(userID string) ([]dao.APIKey, error) {
+	return dao.FindAPIKeys(map[string]interface{}{
+		"where": [][]interface{}{{"user_id = ?", userID}},
+		"order": "created_at desc",
+	})
+}
+
+func RevokeAPIKey(userID string, id string) error {
+	m, err := dao.FindUserAPIKey(userID, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("密钥不存在")
+		} else {
+			return err
+		}
+	}
+	return m.Revoke()
+}
@@ -1,6 +1,8 @@
 package dto
 
 import (
+	"app/lib/event"
+	"app/lib/nestedset"
 	"app/repository/dao"
 	"errors"
 	"fmt"
@@ -10,6 +12,24 @@ import (
 	"gorm.io/gorm"
 )
 
+// findOwnCategory load category id from the tree of userID, categories of other trees
+// are reported as missing. An empty userID stands for the shared tree, handlers only pass it
+// to those allowed to manage categories
+func findOwnCategory(id uint, userID string, options map[string]interface{}, missing string) (dao.Category, error) {
+	m, err := dao.FindCategory(id, options)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return m, errors.New(missing)
+		} else {
+			return m, err
+		}
+	}
+	if m.OwnerID != userID {
+		return m, errors.New(missing)
+	}
+	return m, nil
+}
+
 type NewCategory struct {
 	Name        string `binding:"omitempty,lt=200" json:"name"`
 	Description string `json:"description"`
@@ -20,15 +40,22 @@ func (body *NewCategory) Create(userID string) (dao.Category, error) {
 	m := dao.Category{
 		Name: body.Name, Description: body.Description,
 	}
-	var parentID uint = 1
+	var parent dao.Category
+	var err error
 	if body.ParentID != nil {
-		parentID = uint(*body.ParentID)
+		parent, err = findOwnCategory(uint(*body.ParentID), userID, nil, "父分类不存在")
+	} else {
+		parent, err = dao.FindCategoryRoot(nil, userID)
 	}
-	parent, err := dao.FindCategory(parentID, nil)
 	if err != nil {
 		return m, err
 	}
-	return m.Create(&parent)
+	created, err := m.Create(nil, &parent)
+	if err != nil {
+		return created, err
+	}
+	publishCategoryEvent(event.CategoryCreated, created, created)
+	return created, nil
 }
 
 type UpdateCategory struct {
@@ -36,21 +63,22 @@ type UpdateCategory struct {
 	Description string `json:"description"`
 }
 
-func (body *UpdateCategory) Save(id uint) (dao.Category, error) {
-	m, err := dao.FindCategory(id, nil)
+func (body *UpdateCategory) Save(id uint, userID string) (dao.Category, error) {
+	m, err := findOwnCategory(id, userID, nil, "分类不存在")
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return m, errors.New("分类不存在")
-		} else {
-			return m, err
-		}
+		return m, err
 	}
 	values := map[string]interface{}{
 		"name":        body.Name,
 		"description": body.Description,
 	}
 	values = omitEmpty(values)
-	return m.Update(values)
+	updated, err := m.Update(values)
+	if err != nil {
+		return updated, err
+	}
+	publishCategoryEvent(event.CategoryUpdated, updated, updated)
+	return updated, nil
 }
 
 type QueryCategory struct {
@@ -61,8 +89,9 @@ type QueryCategory struct {
 	SortOrder string `form:"sortOrder,default=desc" binding:"oneof=asc desc"`
 }
 
-func (query *QueryCategory) Find() ([]dao.Category, int64, error) {
-	where := make([][]interface{}, 0)
+// Find list categories of the tree owned by userID, the shared tree when userID is empty
+func (query *QueryCategory) Find(userID string) ([]dao.Category, int64, error) {
+	where := [][]interface{}{{"owner_id = ?", userID}}
 	if query.Key != "" {
 		where = append(where, []interface{}{"name LIKE ?", fmt.Sprintf("%%%s%%", query.Key)})
 	}
@@ -75,6 +104,11 @@ func (query *QueryCategory) Find() ([]dao.Category, int64, error) {
 	})
 }
 
+// QueryCategoryTree limit how many levels of descendants are fetched with a category, 0 fetches all
+type QueryCategoryTree struct {
+	Depth int `form:"depth,default=0" binding:"min=0,max=100" json:"depth"`
+}
+
 func isPostExists(row dao.Post, rows []dao.Post) bool {
 	for i := 0; i < len(rows); i++ {
 		if row.ID == rows[i].ID {
@@ -84,25 +118,30 @@ func isPostExists(row dao.Post, rows []dao.Post) bool {
 	return false
 }
 
+// findVisiblePosts load the posts of comma separated ids that userID may file, its own
+// and public ones, posts of other users stay private and are skipped
+func findVisiblePosts(ids string, userID string) ([]dao.Post, error) {
+	return dao.FindPosts(map[string]interface{}{
+		"where": [][]interface{}{
+			{"id IN (?)", strings.Split(ids, ",")},
+			{"(is_public = ? OR user_id = ?)", true, userID},
+		},
+	})
+}
+
 type IOCategory struct {
 	CategoryID uint   `binding:"required" json:"categoryID"`
 	PostID     string `binding:"required" json:"postID"`
 }
 
-func (body *IOCategory) In() (dao.Category, error) {
-	m, err := dao.FindCategory(body.CategoryID, map[string]interface{}{
+func (body *IOCategory) In(userID string) (dao.Category, error) {
+	m, err := findOwnCategory(body.CategoryID, userID, map[string]interface{}{
 		"preload": []string{"Posts"},
-	})
+	}, "分类不存在")
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return m, errors.New("分类不存在")
-		} else {
-			return m, err
-		}
+		return m, err
 	}
-	rows, err := dao.FindPosts(map[string]interface{}{
-		"where": strings.Split(body.PostID, ","),
-	})
+	rows, err := findVisiblePosts(body.PostID, userID)
 	if err != nil {
 		return m, err
 	}
@@ -116,19 +155,20 @@ func (body *IOCategory) In() (dao.Category, error) {
 	if err != nil {
 		return m, err
 	}
+	if len(next) > 0 {
+		publishCategoryEvent(event.CategoryPostsAdded, CategoryPostsPayload{
+			To: bareCategory(m), PostIDs: postIDs(next),
+		}, m)
+	}
 	return m, nil
 }
 
-func (body *IOCategory) Out() (dao.Category, error) {
-	m, err := dao.FindCategory(body.CategoryID, map[string]interface{}{
+func (body *IOCategory) Out(userID string) (dao.Category, error) {
+	m, err := findOwnCategory(body.CategoryID, userID, map[string]interface{}{
 		"preload": []string{"Posts"},
-	})
+	}, "分类不存在")
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return m, errors.New("分类不存在")
-		} else {
-			return m, err
-		}
+		return m, err
 	}
 	rows, err := dao.FindPosts(map[string]interface{}{
 		"where": strings.Split(body.PostID, ","),
@@ -149,6 +189,9 @@ func (body *IOCategory) Out() (dao.Category, error) {
 	if err != nil {
 		return m, err
 	}
+	publishCategoryEvent(event.CategoryPostsRemoved, CategoryPostsPayload{
+		From: bareCategory(m), PostIDs: postIDs(next),
+	}, m)
 	m.Posts = left
 	return m, nil
 }
@@ -159,30 +202,20 @@ type MovePost struct {
 	PostID string `binding:"required" json:"postID"`
 }
 
-func (body MovePost) Move() (dao.Category, error) {
-	from, err := dao.FindCategory(body.From, map[string]interface{}{
+func (body MovePost) Move(userID string) (dao.Category, error) {
+	from, err := findOwnCategory(body.From, userID, map[string]interface{}{
 		"preload": []string{"Posts"},
-	})
+	}, "来源分类不存在")
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return from, errors.New("来源分类不存在")
-		} else {
-			return from, err
-		}
+		return from, err
 	}
-	to, err := dao.FindCategory(body.To, map[string]interface{}{
+	to, err := findOwnCategory(body.To, userID, map[string]interface{}{
 		"preload": []string{"Posts"},
-	})
+	}, "目标分类不存在")
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return to, errors.New("目标分类不存在")
-		} else {
-			return to, err
-		}
+		return to, err
 	}
-	rows, err := dao.FindPosts(map[string]interface{}{
-		"where": strings.Split(body.PostID, ","),
-	})
+	rows, err := findVisiblePosts(body.PostID, userID)
 	if err != nil {
 		return to, err
 	}
@@ -202,38 +235,257 @@ func (body MovePost) Move() (dao.Category, error) {
 	if err != nil {
 		return to, err
 	}
+	publishCategoryEvent(event.CategoryPostsMoved, CategoryPostsPayload{
+		From: bareCategory(from), To: bareCategory(to), PostIDs: postIDs(toRows),
+	}, from, to)
 	return to, nil
 }
 
 type DeleteCategory struct {
-	ID string `binding:"omitempty" json:"id"`
+	ID       string `binding:"omitempty" json:"id"`
+	Strategy string `binding:"omitempty,oneof=cascade parent root" json:"strategy"`
+	PostsTo  *uint  `binding:"omitempty,gt=0" json:"postsTo"`
 }
 
-func (body DeleteCategory) Delete() error {
+// Delete delete categories of userID, their children move under the root unless Strategy says
+// otherwise, and their posts move to PostsTo if they have any. Every deleted category, moved child
+// and batch of moved posts is announced
+func (body DeleteCategory) Delete(userID string) (dao.CategoryDeletion, error) {
+	strategy := body.Strategy
+	if strategy == "" {
+		strategy = dao.DeleteToRoot
+	}
+	report := dao.CategoryDeletion{Strategy: strategy}
 	ids := make([]uint, 0)
 	for _, id := range strings.Split(body.ID, ",") {
 		id, err := strconv.Atoi(id)
 		if err != nil {
-			return err
+			return report, err
 		}
 		ids = append(ids, uint(id))
 	}
-	return dao.DeleteCategories(ids)
+	rows, err := dao.FindCategories(map[string]interface{}{
+		"where": [][]interface{}{{"id IN (?)", ids}, {"owner_id = ?", userID}},
+	})
+	if err != nil {
+		return report, err
+	}
+	if len(rows) != len(ids) {
+		return report, errors.New("分类不存在")
+	}
+	var postsTo *dao.Category
+	if body.PostsTo != nil {
+		found, err := findOwnCategory(*body.PostsTo, userID, nil, "目标分类不存在")
+		if err != nil {
+			return report, err
+		}
+		postsTo = &found
+	}
+	// topics are resolved before the tree changes, the deleted categories and their
+	// ancestors cover every place something left
+	topics := categoryTopics(nil, rows...)
+	report, err = dao.DeleteCategories(nil, ids, strategy, postsTo, userID)
+	if err != nil {
+		return report, err
+	}
+	for _, id := range report.Deleted {
+		topics = mergeTopics(topics, []string{fmt.Sprintf("category:%d", id)})
+	}
+	deleted := make(map[int64]dao.Category, len(report.Categories))
+	for _, row := range report.Categories {
+		deleted[row.ID] = row
+		event.Publish(event.Event{Type: event.CategoryDeleted, Topics: topics, Payload: bareCategory(row)})
+	}
+	for _, id := range report.Moved {
+		moved, err := dao.FindCategory(uint(id), nil)
+		if err != nil {
+			continue
+		}
+		payload := CategoryMovedPayload{Category: *bareCategory(moved), FromParentID: report.MovedFrom[id]}
+		event.Publish(event.Event{Type: event.CategoryMoved, Topics: mergeTopics(topics, categoryTopics(nil, moved)), Payload: payload})
+	}
+	if postsTo != nil {
+		for from, ids := range report.PostIDs {
+			payload := CategoryPostsPayload{From: bareCategory(deleted[from]), To: bareCategory(*postsTo), PostIDs: ids}
+			event.Publish(event.Event{Type: event.CategoryPostsMoved, Topics: mergeTopics(topics, categoryTopics(nil, *postsTo)), Payload: payload})
+		}
+	}
+	return report, nil
 }
 
 type MoveCategory struct {
 	ID string `binding:"omitempty" json:"id"`
 }
 
-func (body *MoveCategory) Move(parent *dao.Category) (err error) {
+func (body *MoveCategory) Move(parentID uint, userID string) (err error) {
+	parent, err := findOwnCategory(parentID, userID, nil, "目标分类不存在")
+	if err != nil {
+		return err
+	}
+	ids := strings.Split(body.ID, ",")
 	rows, err := dao.FindCategories(map[string]interface{}{
-		"where": strings.Split(body.ID, ","),
+		"where": [][]interface{}{{"id IN (?)", ids}, {"owner_id = ?", userID}},
 	})
-	for _, row := range rows {
-		err := row.MoveTo(parent)
-		if err != nil {
+	if err != nil {
+		return err
+	}
+	if len(rows) != len(ids) {
+		return errors.New("分类不存在")
+	}
+	var events []event.Event
+	err = dao.Transaction(func(tx *gorm.DB) error {
+		events = make([]event.Event, 0, len(rows))
+		if err := dao.LockCategoryTree(tx, parent.OwnerID); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			e, err := moveCategory(tx, row, &parent, nestedset.MoveDirectionInner)
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		event.Publish(e)
+	}
+	return nil
+}
+
+// moveCategory move category m relative to node to within tx and return the event announcing it,
+// the tree shifts with every move so both ends are reloaded first, the event is to be
+// published once tx commits
+func moveCategory(tx *gorm.DB, m dao.Category, to *dao.Category, direction nestedset.MoveDirection) (event.Event, error) {
+	current, err := m.Reload(tx)
+	if err != nil {
+		return event.Event{}, err
+	}
+	target, err := to.Reload(tx)
+	if err != nil {
+		return event.Event{}, err
+	}
+	from := categoryTopics(tx, current)
+	if err := current.MoveTo(tx, &target, direction); err != nil {
+		return event.Event{}, err
+	}
+	moved, err := current.Reload(tx)
+	if err != nil {
+		return event.Event{}, err
+	}
+	payload := CategoryMovedPayload{Category: *bareCategory(moved), FromParentID: current.ParentID.Int64}
+	return event.Event{Type: event.CategoryMoved, Topics: mergeTopics(from, categoryTopics(tx, moved)), Payload: payload}, nil
+}
+
+// reorderPositions map positions of ReorderCategory to move directions
+var reorderPositions = map[string]nestedset.MoveDirection{
+	"before": nestedset.MoveDirectionLeft,
+	"after":  nestedset.MoveDirectionRight,
+	"inside": nestedset.MoveDirectionInner,
+}
+
+type ReorderCategory struct {
+	TargetID uint   `binding:"required,gt=0" json:"targetID"`
+	Position string `binding:"required,oneof=before after inside" json:"position"`
+}
+
+// Reorder move category id before or after target as its sibling, or inside it as first child,
+// and return the subtree of the moved category
+func (body *ReorderCategory) Reorder(id uint, userID string) (dao.Category, error) {
+	m, err := findOwnCategory(id, userID, nil, "分类不存在")
+	if err != nil {
+		return m, err
+	}
+	target, err := findOwnCategory(body.TargetID, userID, nil, "目标分类不存在")
+	if err != nil {
+		return m, err
+	}
+	if !m.ParentID.Valid {
+		return m, errors.New("不可移动根分类")
+	}
+	if !target.ParentID.Valid && body.Position != "inside" {
+		return m, errors.New("不可移动到根分类同级")
+	}
+	below, err := target.IsDescendantOf(m)
+	if err != nil {
+		return m, err
+	}
+	if target.ID == m.ID || below {
+		return m, errors.New("不可移动到自身或子分类")
+	}
+	var moved event.Event
+	err = dao.Transaction(func(tx *gorm.DB) (err error) {
+		if err := dao.LockCategoryTree(tx, m.OwnerID); err != nil {
 			return err
 		}
+		moved, err = moveCategory(tx, m, &target, reorderPositions[body.Position])
+		return err
+	})
+	if err != nil {
+		return m, err
 	}
-	return
+	event.Publish(moved)
+	return dao.FindCategoryHierarchy(id, 0, nil)
+}
+
+type CopyCategory struct {
+	ParentID *uint  `binding:"omitempty,gt=0" json:"parentID"`
+	Posts    string `binding:"omitempty,oneof=none move duplicate" json:"posts"`
+}
+
+// Copy copy category id and its descendants into parent, the root of the tree of userID by default,
+// and return the copied subtree. The shared tree and the own tree can be copied, posts can only be
+// moved out of the own tree and private posts of other users stay behind
+func (body *CopyCategory) Copy(id uint, userID string) (dao.Category, error) {
+	m, err := dao.FindCategory(id, nil)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return m, errors.New("分类不存在")
+		} else {
+			return m, err
+		}
+	}
+	if m.OwnerID != "" && m.OwnerID != userID {
+		return m, errors.New("分类不存在")
+	}
+	posts := body.Posts
+	if posts == "" {
+		posts = dao.CopyPostsNone
+	}
+	if posts == dao.CopyPostsMove && m.OwnerID != userID {
+		return m, errors.New("不可移动共享分类的文章")
+	}
+	var parent dao.Category
+	if body.ParentID != nil {
+		parent, err = findOwnCategory(*body.ParentID, userID, nil, "目标分类不存在")
+	} else {
+		parent, err = dao.FindCategoryRoot(nil, userID)
+	}
+	if err != nil {
+		return m, err
+	}
+	copied, report, err := m.CopyTo(nil, &parent, posts, userID)
+	if err != nil {
+		return copied, err
+	}
+	publishCategoryEvent(event.CategoryCreated, copied, copied)
+	for from, ids := range report.PostIDs {
+		source, err := dao.FindCategory(uint(from), nil)
+		if err != nil {
+			continue
+		}
+		target, err := dao.FindCategory(uint(report.Copies[from]), nil)
+		if err != nil {
+			continue
+		}
+		publishCategoryEvent(event.CategoryPostsMoved, CategoryPostsPayload{
+			From: bareCategory(source), To: bareCategory(target), PostIDs: ids,
+		}, source, target)
+	}
+	return dao.FindCategoryHierarchy(uint(copied.ID), 0, map[string]interface{}{
+		"preload": []string{"Posts"},
+	})
 }
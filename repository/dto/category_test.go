@@ -0,0 +1,245 @@
+package dto
+
+import (
+	"app/lib/event"
+	"app/repository/dao"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCategoryConcurrentWrites(t *testing.T) {
+	setupDB(t)
+	// a tree of its own so runs against a shared mysql database do not interfere
+	userID := uuid.NewV4().String()
+
+	var locker sync.Mutex
+	ids := make([]int64, 0)
+	pick := func(r *rand.Rand) int64 {
+		locker.Lock()
+		defer locker.Unlock()
+		return ids[r.Intn(len(ids))]
+	}
+	for i := 0; i < 10; i++ {
+		created, err := (&NewCategory{Name: fmt.Sprintf("seed-%d", i)}).Create(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.ID)
+	}
+
+	var done int64
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 25; i++ {
+				var err error
+				switch r.Intn(3) {
+				case 0:
+					parentID := pick(r)
+					var created dao.Category
+					created, err = (&NewCategory{Name: fmt.Sprintf("c-%d-%d", w, i), ParentID: &parentID}).Create(userID)
+					if err == nil {
+						locker.Lock()
+						ids = append(ids, created.ID)
+						locker.Unlock()
+					}
+				case 1:
+					err = (&MoveCategory{ID: strconv.FormatInt(pick(r), 10)}).Move(uint(pick(r)), userID)
+				default:
+					positions := []string{"before", "after", "inside"}
+					_, err = (&ReorderCategory{TargetID: uint(pick(r)), Position: positions[r.Intn(3)]}).Reorder(uint(pick(r)), userID)
+				}
+				// moves into the own subtree are rejected, anything else must go through
+				if err == nil {
+					atomic.AddInt64(&done, 1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if done == 0 {
+		t.Fatal("no write succeeded")
+	}
+	report, err := dao.VerifyCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("tree is corrupted after %d writes: %+v", done, report)
+	}
+}
+
+// createCategory create a category named name below parentID of userID, under the root when parentID is 0
+func createCategory(t *testing.T, userID string, name string, parentID int64) dao.Category {
+	t.Helper()
+	body := NewCategory{Name: name}
+	if parentID != 0 {
+		body.ParentID = &parentID
+	}
+	created, err := body.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func createPost(t *testing.T, userID string, title string, categoryID int64, public bool) dao.Post {
+	t.Helper()
+	created, err := dao.Post{Title: title, UserID: userID, CategoryID: uint(categoryID), IsPublic: public}.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func TestDeleteCategoryAnnouncesEverything(t *testing.T) {
+	setupDB(t)
+	userID := uuid.NewV4().String()
+	a := createCategory(t, userID, "a", 0)
+	a1 := createCategory(t, userID, "a1", a.ID)
+	a11 := createCategory(t, userID, "a11", a1.ID)
+	b := createCategory(t, userID, "b", 0)
+	c := createCategory(t, userID, "c", 0)
+	c1 := createCategory(t, userID, "c1", c.ID)
+	createPost(t, userID, "own private", a1.ID, false)
+	createPost(t, "someone-else", "foreign public", a11.ID, true)
+	events := recordEvents(t)
+
+	postsTo := uint(b.ID)
+	report, err := (DeleteCategory{ID: strconv.FormatInt(a.ID, 10), Strategy: dao.DeleteCascade, PostsTo: &postsTo}).Delete(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 3 || report.Posts != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if n := countEvents(events(), event.CategoryDeleted); n != 3 {
+		t.Fatalf("expected the cascade to announce 3 deleted categories, got %d", n)
+	}
+	if n := countEvents(events(), event.CategoryPostsMoved); n != 2 {
+		t.Fatalf("expected posts moved out of 2 categories to be announced, got %d", n)
+	}
+
+	_, err = (DeleteCategory{ID: strconv.FormatInt(c.ID, 10), Strategy: dao.DeleteToParent}).Delete(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var moved *CategoryMovedPayload
+	for _, e := range events() {
+		if payload, ok := e.Payload.(CategoryMovedPayload); ok && e.Type == event.CategoryMoved {
+			moved = &payload
+		}
+	}
+	if moved == nil || moved.Category.ID != c1.ID || moved.FromParentID != c.ID {
+		t.Fatalf("expected the move of the child to be announced, got %+v", moved)
+	}
+}
+
+func TestDeleteCategoryKeepsForeignPrivatePosts(t *testing.T) {
+	setupDB(t)
+	userID := uuid.NewV4().String()
+	a := createCategory(t, userID, "a", 0)
+	b := createCategory(t, userID, "b", 0)
+	post := createPost(t, "someone-else", "foreign private", a.ID, false)
+
+	postsTo := uint(b.ID)
+	_, err := (DeleteCategory{ID: strconv.FormatInt(a.ID, 10), PostsTo: &postsTo}).Delete(userID)
+	if err == nil {
+		t.Fatal("expected deleting a category holding private posts of others to be refused")
+	}
+	found, err := dao.FindPost(post.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(found.CategoryID) != a.ID {
+		t.Fatalf("foreign private post moved to %d", found.CategoryID)
+	}
+	if _, err := dao.FindCategory(uint(a.ID), nil); err != nil {
+		t.Fatalf("category was deleted: %v", err)
+	}
+}
+
+func TestCopyCategoryMovesOnlyVisiblePosts(t *testing.T) {
+	setupDB(t)
+	userID := uuid.NewV4().String()
+	a := createCategory(t, userID, "a", 0)
+	b := createCategory(t, userID, "b", 0)
+	own := createPost(t, userID, "own private", a.ID, false)
+	public := createPost(t, "someone-else", "foreign public", a.ID, true)
+	private := createPost(t, "someone-else", "foreign private", a.ID, false)
+	events := recordEvents(t)
+
+	parentID := uint(b.ID)
+	copied, err := (&CopyCategory{ParentID: &parentID, Posts: dao.CopyPostsMove}).Copy(uint(a.ID), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{own.ID: copied.ID, public.ID: copied.ID, private.ID: a.ID}
+	for id, categoryID := range expected {
+		found, err := dao.FindPost(id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(found.CategoryID) != categoryID {
+			t.Errorf("post %s is in category %d, expected %d", found.Title, found.CategoryID, categoryID)
+		}
+	}
+	var moved *CategoryPostsPayload
+	for _, e := range events() {
+		if payload, ok := e.Payload.(CategoryPostsPayload); ok && e.Type == event.CategoryPostsMoved {
+			moved = &payload
+		}
+	}
+	if moved == nil || moved.From.ID != a.ID || moved.To.ID != copied.ID || len(moved.PostIDs) != 2 {
+		t.Fatalf("expected the moved posts to be announced, got %+v", moved)
+	}
+}
+
+func TestForeignPrivatePostsCannotBeFiled(t *testing.T) {
+	setupDB(t)
+	alice, bob := uuid.NewV4().String(), uuid.NewV4().String()
+	aliceCategory := createCategory(t, alice, "a", 0)
+	from := createCategory(t, bob, "from", 0)
+	to := createCategory(t, bob, "to", 0)
+	private := createPost(t, alice, "alice private", aliceCategory.ID, false)
+	public := createPost(t, alice, "alice public", aliceCategory.ID, true)
+	events := recordEvents(t)
+
+	ids := private.ID + "," + public.ID
+	filed, err := (&IOCategory{CategoryID: uint(from.ID), PostID: ids}).In(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filed.Posts) != 1 || filed.Posts[0].ID != public.ID {
+		t.Fatalf("expected only the public post to be filed, got %+v", filed.Posts)
+	}
+	if _, err := (MovePost{From: uint(from.ID), To: uint(to.ID), PostID: ids}).Move(bob); err != nil {
+		t.Fatal(err)
+	}
+	found, err := dao.FindPost(private.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(found.CategoryID) != aliceCategory.ID {
+		t.Fatalf("private post of another user was moved to category %d", found.CategoryID)
+	}
+	for _, e := range events() {
+		if payload, ok := e.Payload.(CategoryPostsPayload); ok {
+			for _, id := range payload.PostIDs {
+				if id == private.ID {
+					t.Fatalf("%s announced a private post of another user", e.Type)
+				}
+			}
+		}
+	}
+}
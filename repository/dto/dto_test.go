@@ -0,0 +1,65 @@
+package dto
+
+import (
+	"app/lib/event"
+	"app/repository/dao"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupDB point dao at the mysql database of APP_TEST_MYSQL_DSN, or at a fresh sqlite file when it is unset,
+// sqlite takes its write lock when a transaction begins so concurrent writers queue instead of failing
+func setupDB(t *testing.T) {
+	t.Helper()
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	if dsn := os.Getenv("APP_TEST_MYSQL_DSN"); dsn != "" {
+		dao.InitWith(mysql.Open(dsn), config)
+	} else {
+		path := filepath.Join(t.TempDir(), "test.db")
+		dao.InitWith(sqlite.Open(path+"?_txlock=immediate&_busy_timeout=30000"), config)
+	}
+	t.Cleanup(func() { _ = dao.Close() })
+}
+
+// recordEvents collect events published until the test ends
+func recordEvents(t *testing.T) func() []event.Event {
+	t.Helper()
+	var locker sync.Mutex
+	recording := true
+	events := make([]event.Event, 0)
+	event.Subscribe(func(e event.Event) {
+		locker.Lock()
+		defer locker.Unlock()
+		if recording {
+			events = append(events, e)
+		}
+	})
+	t.Cleanup(func() {
+		locker.Lock()
+		defer locker.Unlock()
+		recording = false
+	})
+	return func() []event.Event {
+		locker.Lock()
+		defer locker.Unlock()
+		return append([]event.Event{}, events...)
+	}
+}
+
+// countEvents count events of kind
+func countEvents(events []event.Event, kind string) int {
+	n := 0
+	for _, e := range events {
+		if e.Type == kind {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package dto
+
+import (
+	"app/lib/event"
+	"app/lib/logger"
+	"app/repository/dao"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// categoryTopics list topics of categories and of all their ancestors,
+// following a category means following the whole tree below it, tx is the transaction
+// the categories were read in or nil
+func categoryTopics(tx *gorm.DB, categories ...dao.Category) []string {
+	topics := make([]string, 0)
+	for _, category := range categories {
+		ids, err := category.PathIDs(tx)
+		if err != nil {
+			logger.Logger.Error("failed to resolve category topics", zap.Int64("id", category.ID), zap.Error(err))
+		}
+		for _, id := range ids {
+			topics = append(topics, fmt.Sprintf("category:%d", id))
+		}
+	}
+	return mergeTopics(topics)
+}
+
+func mergeTopics(topics ...[]string) []string {
+	seen := make(map[string]bool)
+	merged := make([]string, 0)
+	for _, list := range topics {
+		for _, topic := range list {
+			if !seen[topic] {
+				seen[topic] = true
+				merged = append(merged, topic)
+			}
+		}
+	}
+	return merged
+}
+
+// CanFollowTopic tell whether userID may follow topic, categories of a private tree are
+// only open to its owner
+func CanFollowTopic(userID string, topic string) bool {
+	if !strings.HasPrefix(topic, "category:") {
+		return true
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(topic, "category:"), 10, 64)
+	if err != nil {
+		return false
+	}
+	found, err := dao.FindCategory(uint(id), nil)
+	if err != nil {
+		return false
+	}
+	return found.OwnerID == "" || found.OwnerID == userID
+}
+
+func publishCategoryEvent(kind string, payload interface{}, categories ...dao.Category) {
+	event.Publish(event.Event{Type: kind, Topics: categoryTopics(nil, categories...), Payload: payload})
+}
+
+// publishPostEvent emit an event about post, private posts are only announced to their owner
+func publishPostEvent(kind string, post dao.Post, categoryIDs ...uint) {
+	topics := []string{"user:" + post.UserID}
+	if post.IsPublic {
+		topics = append(topics, "post:"+post.ID)
+		categories := make([]dao.Category, 0, len(categoryIDs))
+		for _, id := range categoryIDs {
+			if category, err := dao.FindCategory(id, nil); err == nil {
+				categories = append(categories, category)
+			}
+		}
+		topics = append(topics, categoryTopics(nil, categories...)...)
+	}
+	event.Publish(event.Event{Type: kind, Topics: topics, Payload: post})
+}
+
+// CategoryMovedPayload describes a category moved away from FromParentID
+type CategoryMovedPayload struct {
+	Category     dao.Category `json:"category"`
+	FromParentID int64        `json:"fromParentID"`
+}
+
+// CategoryPostsPayload describes posts added to, removed from or moved between categories
+type CategoryPostsPayload struct {
+	From    *dao.Category `json:"from,omitempty"`
+	To      *dao.Category `json:"to,omitempty"`
+	PostIDs []string      `json:"postIDs"`
+}
+
+// bareCategory strip relations off category so events don't leak posts
+func bareCategory(category dao.Category) *dao.Category {
+	category.Posts, category.Parent, category.Children, category.Parents = nil, nil, nil, nil
+	return &category
+}
+
+func postIDs(rows []dao.Post) []string {
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
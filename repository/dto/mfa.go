@@ -0,0 +1,222 @@
+package dto
+
+import (
+	"app/lib/totp"
+	"app/repository/dao"
+	"app/util"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PurposeMFA = "mfa"
+
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var errMFAFailed = errors.New("验证码不正确")
+
+func findUser(id string) (dao.User, error) {
+	user, err := dao.FindUser(id, nil)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, errors.New("用户不存在")
+		} else {
+			return user, err
+		}
+	}
+	return user, nil
+}
+
+// MFAToken issue the short-lived token which a login with totp enabled gets
+// instead of an access token, it is exchanged through VerifyMFA
+func MFAToken(user dao.User) (string, error) {
+	return util.GeneratePurposeToken(PurposeMFA, map[string]interface{}{"sub": user.ID}, mfaTokenTTL)
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := util.RandomToken(5)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// verifySecondFactor accept either a totp code which was not used before or an unused recovery code
+func verifySecondFactor(user dao.User, code string, recoveryCode string) error {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return errMFAFailed
+		}
+		used, err := user.UseTOTPStep(step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return errMFAFailed
+		}
+		return nil
+	}
+	if recoveryCode != "" {
+		used, err := dao.UseRecoveryCode(user.ID, normalizeRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return errMFAFailed
+		}
+		return nil
+	}
+	return errMFAFailed
+}
+
+// EnrollTOTP generate a pending totp secret for user, it takes effect once confirmed
+func EnrollTOTP(userID string, issuer string) (map[string]interface{}, error) {
+	user, err := findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := user.Update(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"secret": secret, "uri": totp.URI(issuer, user.Username, secret),
+	}, nil
+}
+
+type ConfirmTOTP struct {
+	Code string `binding:"required,len=6" json:"code"`
+}
+
+// Confirm enable totp after the first valid code and returns fresh recovery codes
+func (body *ConfirmTOTP) Confirm(userID string) ([]string, error) {
+	user, err := findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+	if err := verifySecondFactor(user, body.Code, ""); err != nil {
+		return nil, err
+	}
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := dao.ReplaceRecoveryCodes(user.ID, codes); err != nil {
+		return nil, err
+	}
+	if _, err := user.Update(map[string]interface{}{"totp_enabled": true}); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+type DisableTOTP struct {
+	Code         string `binding:"required_without=RecoveryCode" json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+func (body *DisableTOTP) Disable(userID string) error {
+	user, err := findUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return errors.New("未启用两步验证")
+	}
+	if err := verifySecondFactor(user, body.Code, body.RecoveryCode); err != nil {
+		return err
+	}
+	if err := dao.ReplaceRecoveryCodes(user.ID, nil); err != nil {
+		return err
+	}
+	_, err = user.Update(map[string]interface{}{
+		"totp_enabled": false, "totp_secret": "", "totp_last_step": 0,
+	})
+	return err
+}
+
+type RegenerateRecoveryCodes struct {
+	Code string `binding:"required,len=6" json:"code"`
+}
+
+func (body *RegenerateRecoveryCodes) Regenerate(userID string) ([]string, error) {
+	user, err := findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, errors.New("未启用两步验证")
+	}
+	if err := verifySecondFactor(user, body.Code, ""); err != nil {
+		return nil, err
+	}
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	return codes, dao.ReplaceRecoveryCodes(user.ID, codes)
+}
+
+type VerifyMFA struct {
+	MFAToken     string `binding:"required" json:"mfaToken"`
+	Code         string `binding:"required_without=RecoveryCode" json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// Verify exchange the mfa token of a pending login for its user,
+// failures count against the same throttles as password logins
+func (body *VerifyMFA) Verify(ip string) (dao.User, error) {
+	var user dao.User
+	claims, err := util.DecodePurposeToken(body.MFAToken, PurposeMFA)
+	if err != nil {
+		return user, errors.New("令牌无效")
+	}
+	userID, _ := claims["sub"].(string)
+	key := fmt.Sprintf("mfa:%s", userID)
+	if locked, _ := LoginUsernameThrottle.Locked(key); locked {
+		return user, errLoginLocked
+	}
+	if locked, _ := LoginIPThrottle.Locked(ip); locked {
+		return user, errLoginLocked
+	}
+	user, err = findUser(userID)
+	if err != nil {
+		return user, err
+	}
+	if !user.IsActived || !user.TOTPEnabled {
+		return dao.User{}, errors.New("令牌无效")
+	}
+	if err := verifySecondFactor(user, body.Code, body.RecoveryCode); err != nil {
+		recordLoginFailure(key, ip)
+		return dao.User{}, err
+	}
+	LoginUsernameThrottle.Reset(key)
+	return user, nil
+}
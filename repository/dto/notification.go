@@ -0,0 +1,99 @@
+package dto
+
+import (
+	"app/lib/logger"
+	"app/lib/ws"
+	"app/repository/dao"
+	"encoding/json"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// pendingNotificationLimit caps how many missed notifications are pushed to a client on connect
+const pendingNotificationLimit = 100
+
+// notificationMessage is the envelope notifications are pushed to clients in
+type notificationMessage struct {
+	Type         string           `json:"type"`
+	Notification dao.Notification `json:"notification"`
+}
+
+// Notify store a notification of kind for userID and push it to the user's clients on every node,
+// the node a client is connected to marks it delivered, see NotificationDelivered. Until then it is
+// also pushed on the next connect, so clients may see it twice and have to tell by its id.
+func Notify(userID string, kind string, content interface{}) (dao.Notification, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return dao.Notification{}, err
+	}
+	m := dao.Notification{UserID: userID, Type: kind, Content: string(data)}
+	created, err := m.Create()
+	if err != nil {
+		return created, err
+	}
+	return created, ws.WebsocketServer.SendTo(notificationMessage{Type: "notification", Notification: created}, userID)
+}
+
+// NotificationDelivered mark the notification carried by data delivered once it reached a client
+// of userID, other messages are ignored
+func NotificationDelivered(userID string, data []byte) {
+	var msg struct {
+		Type         string `json:"type"`
+		Notification struct {
+			ID     string `json:"id"`
+			UserID string `json:"userID"`
+		} `json:"notification"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "notification" || msg.Notification.UserID != userID {
+		return
+	}
+	if err := dao.MarkNotificationsDelivered([]string{msg.Notification.ID}); err != nil {
+		logger.Logger.Error("failed to mark notification delivered", zap.String("id", msg.Notification.ID), zap.Error(err))
+	}
+}
+
+// DeliverPendingNotifications push notifications which never reached a client of its user to c
+func DeliverPendingNotifications(c *ws.Client) {
+	rows, err := dao.TakePendingNotifications(c.UserID, pendingNotificationLimit)
+	if err != nil {
+		logger.Logger.Error("failed to deliver pending notifications", zap.String("userID", c.UserID), zap.Error(err))
+	}
+	for _, row := range rows {
+		c.Send(notificationMessage{Type: "notification", Notification: row})
+	}
+}
+
+type QueryNotification struct {
+	Unread bool `form:"unread" json:"unread"`
+	Page   int  `form:"page,default=1" binding:"min=1" json:"page"`
+	Limit  int  `form:"limit,default=10" binding:"min=1,max=100" json:"limit"`
+}
+
+func (query *QueryNotification) Find(userID string) ([]dao.Notification, int64, error) {
+	where := [][]interface{}{{"user_id = ?", userID}}
+	if query.Unread {
+		where = append(where, []interface{}{"read_at IS NULL"})
+	}
+	return dao.FindAndCountNotifications(map[string]interface{}{
+		"where":  where,
+		"offset": (query.Page - 1) * query.Limit,
+		"limit":  query.Limit,
+		"order":  "created_at desc",
+	})
+}
+
+type ReadNotification struct {
+	ID string `binding:"omitempty" json:"id"`
+}
+
+// Read mark notifications listed by comma separated ID as read, all of them when ID is empty
+func (body *ReadNotification) Read(userID string) error {
+	ids := make([]string, 0)
+	for _, id := range strings.Split(body.ID, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return dao.ReadNotifications(userID, ids)
+}
@@ -0,0 +1,38 @@
+package dto
+
+import (
+	"app/repository/dao"
+	"encoding/json"
+	"testing"
+)
+
+func TestNotificationDelivered(t *testing.T) {
+	setupDB(t)
+	created, err := dao.Notification{UserID: "alice", Type: "test", Content: "{}"}.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(notificationMessage{Type: "notification", Notification: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := func() bool {
+		found, _, err := dao.FindAndCountNotifications(map[string]interface{}{
+			"where": [][]interface{}{{"id = ?", created.ID}, {"delivered_at IS NOT NULL"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(found) > 0
+	}
+
+	// a message reaching a client of someone else says nothing about the notification
+	NotificationDelivered("bob", data)
+	if delivered() {
+		t.Fatal("notification marked delivered by a client of another user")
+	}
+	NotificationDelivered("alice", data)
+	if !delivered() {
+		t.Fatal("notification not marked delivered")
+	}
+}
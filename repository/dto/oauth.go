@@ -0,0 +1,123 @@
+package dto
+
+import (
+	"app/lib/oauth"
+	"app/repository/dao"
+	"app/util"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	PurposeOAuth = "oauth"
+
+	oauthStateTTL = 10 * time.Minute
+)
+
+var errOAuthState = errors.New("登录请求已失效，请重新登录")
+
+// OAuthState sign the values of an authorization request so the client can carry them to the callback
+func OAuthState(provider string, req oauth.AuthRequest) (string, error) {
+	return util.GeneratePurposeToken(PurposeOAuth, map[string]interface{}{
+		"provider": provider, "state": req.State, "verifier": req.Verifier, "nonce": req.Nonce,
+	}, oauthStateTTL)
+}
+
+// DecodeOAuthState restore the authorization request signed by OAuthState,
+// it must have been started for provider and carry the state returned by the provider
+func DecodeOAuthState(token string, provider string, state string) (oauth.AuthRequest, error) {
+	var req oauth.AuthRequest
+	claims, err := util.DecodePurposeToken(token, PurposeOAuth)
+	if err != nil {
+		return req, errOAuthState
+	}
+	req.State, _ = claims["state"].(string)
+	req.Verifier, _ = claims["verifier"].(string)
+	req.Nonce, _ = claims["nonce"].(string)
+	if claims["provider"] != provider || req.State == "" || req.State != state {
+		return req, errOAuthState
+	}
+	return req, nil
+}
+
+var usernamePattern = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// oauthUsername derive a free username from identity, a random suffix is appended on conflict
+func oauthUsername(identity oauth.Identity) (string, error) {
+	base := identity.Name
+	if base == "" && identity.Email != "" {
+		base = strings.Split(identity.Email, "@")[0]
+	}
+	base = usernamePattern.ReplaceAllString(base, "")
+	if len(base) > 80 {
+		base = base[:80]
+	}
+	if base == "" {
+		base = identity.Provider
+	}
+	if exists, _ := dao.FindByUsername(base); !exists {
+		return base, nil
+	}
+	for i := 0; i < 5; i++ {
+		suffix, err := util.RandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		username := base + "_" + suffix
+		if exists, _ := dao.FindByUsername(username); !exists {
+			return username, nil
+		}
+	}
+	return "", errors.New("无法生成用户名")
+}
+
+// OAuthLogin find the user signing in through identity. An identity seen before logs into its user,
+// otherwise it is linked to the user owning the same verified email, or a new active user is created.
+// Emails are only matched when both the provider and the local account have verified them,
+// so nobody can take over an account by registering its email first.
+func OAuthLogin(identity oauth.Identity, defaultRole string) (dao.User, error) {
+	if exists, found := dao.FindUserIdentity(identity.Provider, identity.Subject); exists {
+		if found.User == nil || !found.User.IsActived {
+			return dao.User{}, errLoginFailed
+		}
+		return found.User.Update(map[string]interface{}{"last_logined_at": time.Now()})
+	}
+	link := dao.UserIdentity{Provider: identity.Provider, Subject: identity.Subject, Email: identity.Email}
+	if identity.Email != "" {
+		if exists, found := dao.FindByEmail(identity.Email); exists {
+			if !identity.EmailVerified || found.EmailVerifiedAt == nil {
+				return dao.User{}, errors.New("邮箱已被使用，请先使用密码登录并验证邮箱")
+			}
+			if !found.IsActived {
+				return dao.User{}, errLoginFailed
+			}
+			link.UserID = found.ID
+			if _, err := link.Create(); err != nil {
+				return found, err
+			}
+			return found.Update(map[string]interface{}{"last_logined_at": time.Now()})
+		}
+	}
+	username, err := oauthUsername(identity)
+	if err != nil {
+		return dao.User{}, err
+	}
+	// the account has no usable password until the user resets one by email
+	password, err := util.RandomToken(32)
+	if err != nil {
+		return dao.User{}, err
+	}
+	user := dao.User{
+		Username: username, Password: password, Avatar: identity.Avatar, IsActived: true,
+	}
+	if identity.EmailVerified {
+		user.Email = identity.Email
+		user.EmailVerifiedAt = &util.LocalTime{Time: time.Now()}
+	}
+	if exists, role := dao.FindRoleByName(defaultRole); exists {
+		user.Roles = []dao.Role{role}
+	}
+	return dao.CreateUserWithIdentity(user, link)
+}
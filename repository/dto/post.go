@@ -1,6 +1,7 @@
 package dto
 
 import (
+	"app/lib/event"
 	"app/repository/dao"
 	"errors"
 	"fmt"
@@ -8,6 +9,16 @@ import (
 	"gorm.io/gorm"
 )
 
+// findPostCategory load category id to file a post of userID into, it lies in the tree
+// of userID or in the shared tree, categories of other trees are reported as missing
+func findPostCategory(id uint, userID string) (dao.Category, error) {
+	m, err := dao.FindCategory(id, nil)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && m.OwnerID != "" && m.OwnerID != userID) {
+		return m, errors.New("分类不存在")
+	}
+	return m, err
+}
+
 type NewPost struct {
 	Title      string `binding:"omitempty,lt=200" json:"title"`
 	Content    string `json:"content"`
@@ -16,6 +27,9 @@ type NewPost struct {
 }
 
 func (body *NewPost) Create(userID string) (dao.Post, error) {
+	if _, err := findPostCategory(body.CategoryID, userID); err != nil {
+		return dao.Post{}, err
+	}
 	m := dao.Post{
 		Title: body.Title, Content: body.Content,
 		CategoryID: body.CategoryID, UserID: userID,
@@ -27,6 +41,7 @@ func (body *NewPost) Create(userID string) (dao.Post, error) {
 	if err != nil {
 		return created, err
 	}
+	publishPostEvent(event.PostCreated, created, created.CategoryID)
 	return created, nil
 }
 
@@ -37,7 +52,8 @@ type UpdatePost struct {
 	IsPublic   bool   `binding:"omitempty" json:"isPublic"`
 }
 
-func (body *UpdatePost) Save(id string) (dao.Post, error) {
+// Save update post id on behalf of userID, a new category has to be one userID may file posts into
+func (body *UpdatePost) Save(id string, userID string) (dao.Post, error) {
 	m, err := dao.FindPost(id, nil)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -47,9 +63,8 @@ func (body *UpdatePost) Save(id string) (dao.Post, error) {
 		}
 	}
 	if body.CategoryID != nil {
-		exists, _ := dao.CategoryExists(*body.CategoryID)
-		if !exists {
-			return m, errors.New("分类不存在")
+		if _, err := findPostCategory(*body.CategoryID, userID); err != nil {
+			return m, err
 		}
 	}
 	values := map[string]interface{}{
@@ -59,10 +74,12 @@ func (body *UpdatePost) Save(id string) (dao.Post, error) {
 		"is_public":   body.IsPublic,
 	}
 	values = omitEmpty(values)
+	fromCategoryID := m.CategoryID
 	updated, err := m.Update(values)
 	if err != nil {
 		return updated, err
 	}
+	publishPostEvent(event.PostUpdated, updated, fromCategoryID, updated.CategoryID)
 	return updated, nil
 }
 
@@ -0,0 +1,39 @@
+package dto
+
+import (
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPostsAreFiledIntoOwnOrSharedTree(t *testing.T) {
+	setupDB(t)
+	alice, bob := uuid.NewV4().String(), uuid.NewV4().String()
+	aliceCategory := createCategory(t, alice, "a", 0)
+	bobCategory := createCategory(t, bob, "b", 0)
+	shared := createCategory(t, "", "shared", 0)
+
+	if _, err := (&NewPost{Title: "foreign", CategoryID: uint(aliceCategory.ID)}).Create(bob); err == nil {
+		t.Fatal("expected a post to be refused in the tree of another user")
+	}
+	created, err := (&NewPost{Title: "own", CategoryID: uint(bobCategory.ID)}).Create(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&NewPost{Title: "shared", CategoryID: uint(shared.ID)}).Create(bob); err != nil {
+		t.Fatal(err)
+	}
+
+	foreign := uint(aliceCategory.ID)
+	if _, err := (&UpdatePost{CategoryID: &foreign}).Save(created.ID, bob); err == nil {
+		t.Fatal("expected a post to be refused a move into the tree of another user")
+	}
+	sharedID := uint(shared.ID)
+	updated, err := (&UpdatePost{CategoryID: &sharedID}).Save(created.ID, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(updated.CategoryID) != shared.ID {
+		t.Fatalf("post is in category %d, expected %d", updated.CategoryID, shared.ID)
+	}
+}
@@ -0,0 +1,111 @@
+package dto
+
+import (
+	"app/repository/dao"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+func findPermissions(names string) ([]dao.Permission, error) {
+	rows := make([]dao.Permission, 0)
+	if names == "" {
+		return rows, nil
+	}
+	split := strings.Split(names, ",")
+	rows, err := dao.FindPermissions(map[string]interface{}{
+		"where": [][]interface{}{{"name IN (?)", split}},
+	})
+	if err != nil {
+		return rows, err
+	}
+	if len(rows) != len(split) {
+		return rows, errors.New("权限不存在")
+	}
+	return rows, nil
+}
+
+type NewRole struct {
+	Name        string `binding:"required,lt=100" json:"name"`
+	Description string `json:"description"`
+	Permissions string `binding:"omitempty" json:"permissions"`
+}
+
+func (body *NewRole) Create() (dao.Role, error) {
+	m := dao.Role{Name: body.Name, Description: body.Description}
+	exists, _ := dao.FindRoleByName(body.Name)
+	if exists {
+		return m, errors.New("角色已存在")
+	}
+	perms, err := findPermissions(body.Permissions)
+	if err != nil {
+		return m, err
+	}
+	m.Permissions = perms
+	return m.Create()
+}
+
+type UpdateRole struct {
+	Description string  `json:"description"`
+	Permissions *string `binding:"omitempty" json:"permissions"`
+}
+
+func (body *UpdateRole) Save(id uint) (dao.Role, error) {
+	m, err := dao.FindRole(id, nil)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return m, errors.New("角色不存在")
+		} else {
+			return m, err
+		}
+	}
+	if body.Permissions != nil {
+		perms, err := findPermissions(*body.Permissions)
+		if err != nil {
+			return m, err
+		}
+		if err := m.Relations("Permissions").Replace(perms); err != nil {
+			return m, err
+		}
+		m.Permissions = perms
+	}
+	values := map[string]interface{}{
+		"description": body.Description,
+	}
+	values = omitEmpty(values)
+	return m.Update(values)
+}
+
+type AssignRoles struct {
+	Roles string `binding:"required" json:"roles"`
+}
+
+func (body *AssignRoles) Assign(userID string) (dao.User, error) {
+	user, err := dao.FindUser(userID, nil)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, errors.New("用户不存在")
+		} else {
+			return user, err
+		}
+	}
+	names := strings.Split(body.Roles, ",")
+	roles, err := dao.FindRoles(map[string]interface{}{
+		"where": [][]interface{}{{"name IN (?)", names}},
+	})
+	if err != nil {
+		return user, err
+	}
+	if len(roles) != len(names) {
+		return user, errors.New("角色不存在")
+	}
+	if err := user.AssignRoles(roles); err != nil {
+		return user, err
+	}
+	user.Roles = roles
+	if _, err := Notify(user.ID, "role.assigned", map[string]interface{}{"roles": names}); err != nil {
+		return user, err
+	}
+	return user, nil
+}
@@ -0,0 +1,60 @@
+package dto
+
+import (
+	"app/repository/dao"
+	"errors"
+	"strings"
+)
+
+var deviceKeywords = [][2]string{
+	{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"},
+	{"Windows", "Windows"}, {"Macintosh", "Mac"}, {"Linux", "Linux"},
+}
+
+// deviceOf guess a readable device name from user agent
+func deviceOf(userAgent string) string {
+	for _, keyword := range deviceKeywords {
+		if strings.Contains(userAgent, keyword[0]) {
+			return keyword[1]
+		}
+	}
+	return "Unknown"
+}
+
+type NewSession struct {
+	Device    string
+	UserAgent string
+	IP        string
+}
+
+func (body *NewSession) Create(userID string, mfa bool) (dao.Session, error) {
+	m := dao.Session{
+		UserID: userID, Device: body.Device, UserAgent: body.UserAgent, IP: body.IP, MFA: mfa,
+	}
+	if m.Device == "" {
+		m.Device = deviceOf(body.UserAgent)
+	}
+	if len(m.Device) > 200 {
+		m.Device = m.Device[:200]
+	}
+	return m.Create()
+}
+
+func FindSessions(userID string) ([]dao.Session, error) {
+	return dao.FindSessions(map[string]interface{}{
+		"where": [][]interface{}{{"user_id = ? AND revoked_at IS NULL", userID}},
+		"order": "last_seen_at desc",
+	})
+}
+
+func RevokeSession(userID string, id string) error {
+	exists, found := dao.FindSession(id)
+	if !exists || found.UserID != userID {
+		return errors.New("会话不存在")
+	}
+	return found.Revoke()
+}
+
+func RevokeSessions(userID string) error {
+	return dao.RevokeUserSessions([]string{userID})
+}
@@ -0,0 +1,74 @@
+package dto
+
+import (
+	"app/repository/dao"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RefreshToken struct {
+	RefreshToken string `binding:"required" json:"refreshToken"`
+}
+
+// Refresh rotate the refresh token of a client at ip, reusing a revoked token revokes
+// its whole family. Returns the user, the successor token and its plain value
+func (body *RefreshToken) Refresh(ttl time.Duration, ip string) (dao.User, dao.RefreshToken, string, error) {
+	var user dao.User
+	var next dao.RefreshToken
+	exists, found := dao.FindRefreshToken(body.RefreshToken)
+	if !exists {
+		return user, next, "", errors.New("刷新令牌无效")
+	}
+	if found.IsRevoked() {
+		if err := dao.RevokeSession(found.Family); err != nil {
+			return user, next, "", err
+		}
+		return user, next, "", errors.New("刷新令牌已失效")
+	}
+	if found.IsExpired() {
+		return user, next, "", errors.New("刷新令牌已过期")
+	}
+	user, err := dao.FindUser(found.UserID, nil)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, next, "", errors.New("用户不存在")
+		} else {
+			return user, next, "", err
+		}
+	}
+	if !user.IsActived {
+		if err := dao.RevokeSession(found.Family); err != nil {
+			return user, next, "", err
+		}
+		return user, next, "", errors.New("用户未激活")
+	}
+	exists, session := dao.FindSession(found.Family)
+	if exists {
+		if session.IsRevoked() {
+			return user, next, "", errors.New("会话已失效")
+		}
+		if err := session.Touch(ip); err != nil {
+			return user, next, "", err
+		}
+	}
+	token, next, err := found.Rotate(ttl)
+	if err != nil {
+		return user, next, "", err
+	}
+	return user, next, token, nil
+}
+
+type Logout struct {
+	RefreshToken string `binding:"required" json:"refreshToken"`
+}
+
+// Logout revoke the session the refresh token belongs to and return its id
+func (body *Logout) Logout(userID string) (string, error) {
+	exists, found := dao.FindRefreshToken(body.RefreshToken)
+	if !exists || found.UserID != userID {
+		return "", errors.New("刷新令牌无效")
+	}
+	return found.Family, dao.RevokeSession(found.Family)
+}
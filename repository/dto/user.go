@@ -1,12 +1,15 @@
 package dto
 
 import (
+	"app/lib/logger"
+	"app/lib/throttle"
 	"app/repository/dao"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -33,14 +36,16 @@ func (query *QueryUser) Find() ([]dao.User, int64, error) {
 	})
 }
 
+// UpdateUser is the profile users edit themselves, activation is left to ToggleUserActive
 type UpdateUser struct {
-	Email     string `binding:"omitempty,lt=200,email"`
-	Avatar    string `binding:"omitempty,url"`
-	Memo      string `binding:"omitempty"`
-	IsActived bool   `binding:"omitempty" json:"isActived"`
+	Email  string `binding:"omitempty,lt=200,email"`
+	Avatar string `binding:"omitempty,url"`
+	Memo   string `binding:"omitempty"`
 }
 
-func (body *UpdateUser) Save(id string) (dao.User, error) {
+// Save update the profile of user id, a changed email has to be verified again and
+// the verification link built from link is mailed to it
+func (body *UpdateUser) Save(id string, link string) (dao.User, error) {
 	user, err := dao.FindUser(id, nil)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -50,47 +55,114 @@ func (body *UpdateUser) Save(id string) (dao.User, error) {
 		}
 	}
 	values := map[string]interface{}{
-		"email":      body.Email,
-		"avatar":     body.Avatar,
-		"memo":       body.Memo,
-		"is_actived": body.IsActived,
+		"email":  body.Email,
+		"avatar": body.Avatar,
+		"memo":   body.Memo,
 	}
 	values = omitEmpty(values)
-	return user.Update(values)
+	changed := body.Email != "" && !strings.EqualFold(body.Email, user.Email)
+	if changed {
+		if exists, _ := dao.FindByEmail(body.Email); exists {
+			return user, errors.New("邮箱已被使用")
+		}
+		values["email_verified_at"] = nil
+		user.EmailVerifiedAt = nil
+	}
+	updated, err := user.Update(values)
+	if err != nil || !changed {
+		return updated, err
+	}
+	updated.Email = body.Email
+	return updated, SendVerification(nil, updated, link)
 }
 
 type RegisterUser struct {
 	Username       string `binding:"required,lt=100"`
 	Password       string `binding:"required,lt=200"`
 	Repeatpassword string `binding:"required,lt=200,eqfield=Password" json:"repeatPassword"`
-	Email          string `binding:"lt=200,email"`
+	Email          string `binding:"required,lt=200,email"`
 }
 
-func (body *RegisterUser) Create() (dao.User, error) {
+// Create register a user and mail the verification link built from link, the user
+// is not kept when the mail can't be sent so that registering again is possible
+func (body *RegisterUser) Create(defaultRole string, link string) (dao.User, error) {
 	user := dao.User{
-		Username: body.Username,
-		Email:    body.Email,
-		Password: body.Password,
-	}
-	return user.Create()
+		Username:  body.Username,
+		Email:     body.Email,
+		Password:  body.Password,
+		IsActived: true,
+	}
+	exists, role := dao.FindRoleByName(defaultRole)
+	if exists {
+		user.Roles = []dao.Role{role}
+	}
+	var created dao.User
+	err := dao.Transaction(func(tx *gorm.DB) (err error) {
+		if created, err = user.Create(tx); err != nil {
+			return err
+		}
+		return SendVerification(tx, created, link)
+	})
+	return created, err
 }
 
+// LoginUsernameThrottle and LoginIPThrottle limit failed logins per username and per client ip
+var (
+	LoginUsernameThrottle = throttle.New(5, time.Minute, time.Hour, 24*time.Hour)
+	LoginIPThrottle       = throttle.New(20, time.Minute, time.Hour, 24*time.Hour)
+)
+
+var (
+	errLoginFailed     = errors.New("用户名或密码不正确")
+	errEmailUnverified = errors.New("邮箱未验证，请先完成验证")
+	errLoginLocked     = errors.New("登录失败次数过多，请稍后再试")
+)
+
+// dummyPassword is compared against when user doesn't exist so that every failure costs the same
+var dummyPassword, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), 4)
+
 type LoginUser struct {
 	Username string `binding:"required,lt=100" json:"username"`
 	Password string `binding:"required,lt=200"`
 }
 
-func (body *LoginUser) Login() (dao.User, error) {
+// recordLoginFailure count a failure against key and ip, key is usually the lowercased username
+func recordLoginFailure(key string, ip string) {
+	if locked, delay := LoginUsernameThrottle.Fail(key); locked {
+		logger.Logger.Warn("login locked", zap.String("key", key), zap.String("ip", ip), zap.Duration("delay", delay))
+	}
+	if locked, delay := LoginIPThrottle.Fail(ip); locked {
+		logger.Logger.Warn("login locked", zap.String("ip", ip), zap.Duration("delay", delay))
+	}
+}
+
+// Login check credentials of user logging in from ip, every failure yields the same error
+// and repeated failures lock out the username and the ip for a growing period.
+// Users whose email is not verified yet are told so once their password matched
+func (body *LoginUser) Login(ip string) (dao.User, error) {
+	usernameKey := strings.ToLower(body.Username)
+	if locked, _ := LoginUsernameThrottle.Locked(usernameKey); locked {
+		return dao.User{}, errLoginLocked
+	}
+	if locked, _ := LoginIPThrottle.Locked(ip); locked {
+		return dao.User{}, errLoginLocked
+	}
 	exists, found := dao.FindByUsername(body.Username)
-	if !exists {
-		return found, errors.New("用户不存在")
+	hashedPassword := dummyPassword
+	if exists {
+		hashedPassword = []byte(found.Password)
+	}
+	if err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(body.Password)); err != nil || !exists {
+		recordLoginFailure(usernameKey, ip)
+		return dao.User{}, errLoginFailed
 	}
 	if !found.IsActived {
-		return found, errors.New("用户未激活")
+		return dao.User{}, errLoginFailed
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(found.Password), []byte(body.Password)); err != nil {
-		return found, errors.New("密码不正确")
+	if found.EmailVerifiedAt == nil {
+		return dao.User{}, errEmailUnverified
 	}
+	LoginUsernameThrottle.Reset(usernameKey)
 	updated, err := found.Update(map[string]interface{}{"last_logined_at": time.Now()})
 	if err != nil {
 		return updated, err
@@ -104,26 +176,33 @@ type ChangePassword struct {
 	RepeatPassword string `binding:"required,lt=200" json:"repeatPassword"`
 }
 
-func (body *ChangePassword) ChangePassword(id string) (dao.User, error) {
+// ChangePassword set the new password of user id and end every other session of the user,
+// sid is the session making the change, which is kept. Returns the ids of the ended sessions
+func (body *ChangePassword) ChangePassword(id string, sid string) (dao.User, []string, error) {
 	user, err := dao.FindUser(id, nil)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return user, errors.New("用户不存在")
+			return user, nil, errors.New("用户不存在")
 		} else {
-			return user, err
+			return user, nil, err
 		}
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.OldPassword)); err != nil {
-		return user, errors.New("旧密码不正确")
+		return user, nil, errors.New("旧密码不正确")
 	}
 	if body.NewPassword != body.RepeatPassword {
-		return user, errors.New("重复密码不匹配")
+		return user, nil, errors.New("重复密码不匹配")
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), 4)
 	if err != nil {
-		return user, err
+		return user, nil, err
 	}
-	return user.Update(map[string]interface{}{"password": string(hashedPassword)})
+	updated, err := user.Update(map[string]interface{}{"password": string(hashedPassword)})
+	if err != nil {
+		return updated, nil, err
+	}
+	revoked, err := dao.RevokeOtherSessions(id, sid)
+	return updated, revoked, err
 }
 
 type ResetPassword struct {
@@ -147,6 +226,9 @@ func (body *ResetPassword) ResetPassword(id string) (dao.User, error) {
 	if err != nil {
 		return user, err
 	}
+	if err := dao.RevokeUserSessions([]string{user.ID}); err != nil {
+		return user, err
+	}
 	return user.Update(map[string]interface{}{"password": string(hashedPassword)})
 }
 
@@ -165,5 +247,9 @@ func (body ToggleUserActive) Deactive() (err error) {
 	values := map[string]interface{}{
 		"is_actived": false,
 	}
-	return dao.UpdateUsers(values, strings.Split(body.UserID, ","))
+	ids := strings.Split(body.UserID, ",")
+	if err := dao.UpdateUsers(values, ids); err != nil {
+		return err
+	}
+	return dao.RevokeUserSessions(ids)
 }
@@ -0,0 +1,190 @@
+package dto
+
+import (
+	"app/lib/mailer"
+	"app/repository/dao"
+	"app/util"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingMailer keeps sent mails, or fails every send when err is set
+type recordingMailer struct {
+	err    error
+	bodies []string
+}
+
+func (m *recordingMailer) Send(to string, subject string, body string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+func useMailer(t *testing.T, m mailer.Mailer) {
+	t.Helper()
+	previous := mailer.Default
+	mailer.Default = m
+	t.Cleanup(func() { mailer.Default = previous })
+}
+
+var verifyLink = regexp.MustCompile(`http://app/verify\?token=\S+`)
+
+func TestRegisterRollsBackWhenMailFails(t *testing.T) {
+	setupDB(t)
+	if err := util.InitJWT("", "secret", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	useMailer(t, &recordingMailer{err: errors.New("smtp down")})
+
+	body := RegisterUser{Username: "alice", Password: "password", Repeatpassword: "password", Email: "alice@example.com"}
+	if _, err := body.Create("user", "http://app/verify?token={token}"); err == nil {
+		t.Fatal("expected registration to fail with the mail")
+	}
+	if exists, _ := dao.FindByUsername("alice"); exists {
+		t.Fatal("user was kept although the verification mail was never sent")
+	}
+}
+
+func TestLoginRequiresVerifiedEmail(t *testing.T) {
+	setupDB(t)
+	if err := util.InitJWT("", "secret", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	sent := &recordingMailer{}
+	useMailer(t, sent)
+
+	body := RegisterUser{Username: "bob", Password: "password", Repeatpassword: "password", Email: "bob@example.com"}
+	if _, err := body.Create("user", "http://app/verify?token={token}"); err != nil {
+		t.Fatal(err)
+	}
+	login := LoginUser{Username: "bob", Password: "password"}
+	if _, err := login.Login("127.0.0.1"); err != errEmailUnverified {
+		t.Fatalf("expected login to wait for verification, got %v", err)
+	}
+
+	if len(sent.bodies) != 1 {
+		t.Fatalf("expected one verification mail, got %d", len(sent.bodies))
+	}
+	link, err := url.Parse(verifyLink.FindString(sent.bodies[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := VerifyEmail{Token: link.Query().Get("token")}
+	if _, err := verify.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login.Login("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a deactivated user stays locked out although the email is verified
+	_, found := dao.FindByUsername("bob")
+	if err := (ToggleUserActive{UserID: found.ID}).Deactive(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verify.Verify(); err == nil {
+		t.Fatal("expected the verification token to be used up")
+	}
+	if _, err := login.Login("127.0.0.1"); err != errLoginFailed {
+		t.Fatalf("expected a deactivated user to be refused, got %v", err)
+	}
+}
+
+// legacyUser is the users table as it was before emails were verified
+type legacyUser struct {
+	ID            string `gorm:"size:100;primary_key"`
+	Username      string `gorm:"size:100"`
+	Password      string `gorm:"size:200"`
+	Email         string `gorm:"size:200"`
+	IsActived     bool
+	LastLoginedAt time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt
+}
+
+func (legacyUser) TableName() string {
+	return "users"
+}
+
+func TestUsersBeforeVerificationKeepLoggingIn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	legacy, err := gorm.Open(sqlite.Open(path), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.AutoMigrate(&legacyUser{}); err != nil {
+		t.Fatal(err)
+	}
+	password, _ := bcrypt.GenerateFromPassword([]byte("password"), 4)
+	for _, user := range []legacyUser{
+		{ID: "1", Username: "carol", Password: string(password), IsActived: true, LastLoginedAt: time.Now()},
+		{ID: "2", Username: "dave", Password: string(password), IsActived: false, LastLoginedAt: time.Now()},
+	} {
+		if err := legacy.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, err := legacy.DB(); err == nil {
+		_ = d.Close()
+	}
+
+	dao.InitWith(sqlite.Open(path), config)
+	t.Cleanup(func() { _ = dao.Close() })
+	login := LoginUser{Username: "carol", Password: "password"}
+	if _, err := login.Login("127.0.0.1"); err != nil {
+		t.Fatalf("expected an active user from before verification to log in, got %v", err)
+	}
+	if _, found := dao.FindByUsername("dave"); found.EmailVerifiedAt != nil {
+		t.Fatal("an inactive user was marked verified")
+	}
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	setupDB(t)
+	user, err := dao.User{Username: "erin", Password: "password", IsActived: true}.Create(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make([]dao.Session, 0, 2)
+	tokens := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		session, err := (&NewSession{UserAgent: "test"}).Create(user.ID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := dao.IssueRefreshToken(nil, user.ID, session.ID, false, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions, tokens = append(sessions, session), append(tokens, token)
+	}
+
+	body := ChangePassword{OldPassword: "password", NewPassword: "changed", RepeatPassword: "changed"}
+	_, revoked, err := body.ChangePassword(user.ID, sessions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0] != sessions[1].ID {
+		t.Fatalf("expected only the other session to end, got %v", revoked)
+	}
+	for i, kept := range []bool{true, false} {
+		_, session := dao.FindSession(sessions[i].ID)
+		_, token := dao.FindRefreshToken(tokens[i])
+		if (session.RevokedAt == nil) != kept || (token.RevokedAt == nil) != kept {
+			t.Errorf("session %d kept %v, expected %v", i, session.RevokedAt == nil, kept)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func parsePrivateKey(data []byte) (interface{}, interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("invalid pem data")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, &k.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return k, &k.PublicKey, nil
+	case ed25519.PrivateKey:
+		return k, k.Public(), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+func parsePublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem data")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func signingMethodOf(public interface{}) (jwt.SigningMethod, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return SigningMethodEd25519, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// paddedBytes left pads n to size bytes as JWK requires for EC coordinates
+func paddedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// JWKS returns public keys of asymmetric verification keys as a JSON Web Key Set
+func JWKS() map[string]interface{} {
+	kids := make([]string, 0, len(keys.keys))
+	for kid := range keys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	rows := make([]map[string]interface{}, 0)
+	for _, kid := range kids {
+		key := keys.keys[kid]
+		jwk := map[string]interface{}{
+			"kid": key.kid, "use": "sig", "alg": key.method.Alg(),
+		}
+		switch k := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = encodeSegment(k.N.Bytes())
+			jwk["e"] = encodeSegment(big.NewInt(int64(k.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			jwk["kty"] = "EC"
+			jwk["crv"] = k.Curve.Params().Name
+			jwk["x"] = encodeSegment(paddedBytes(k.X, size))
+			jwk["y"] = encodeSegment(paddedBytes(k.Y, size))
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = encodeSegment(k)
+		default:
+			continue
+		}
+		rows = append(rows, jwk)
+	}
+	return map[string]interface{}{"keys": rows}
+}
@@ -1,34 +1,205 @@
 package util
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-func DecodeToken(tokenStr string, secret string) (map[string]interface{}, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// JWTKey describes a key by pem files, PrivateKey may be empty for a key which only verifies
+type JWTKey struct {
+	Kid        string
+	PrivateKey string
+	PublicKey  string
+}
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+type keySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	secret  []byte
+}
+
+var keys = &keySet{keys: map[string]*signingKey{}}
+
+// InitJWT load verification keys and pick the one identified by signingKid to sign new tokens.
+// secret verifies HS256 tokens carrying no kid, it also signs when no key is configured.
+// Without keys and secret it fails, unless ephemeral asks for an Ed25519 key living as long as
+// the process, which is meant for development only as tokens die with every restart.
+func InitJWT(signingKid string, secret string, jwtKeys []JWTKey, ephemeral bool) error {
+	set := &keySet{keys: map[string]*signingKey{}, secret: []byte(secret)}
+	for _, k := range jwtKeys {
+		key, err := loadKey(k)
+		if err != nil {
+			return fmt.Errorf("failed to load jwt key %s: %v", k.Kid, err)
+		}
+		set.keys[key.kid] = key
+	}
+	if len(set.keys) > 0 {
+		key, ok := set.keys[signingKid]
+		if !ok || key.private == nil {
+			return fmt.Errorf("jwt signing key %s not found or has no private key", signingKid)
+		}
+		set.signing = key
+	} else if secret == "" {
+		if !ephemeral {
+			return errors.New("no jwt key or secret configured, set jwt.keys or APP_JWT_SECRET, or jwt.ephemeral for development")
+		}
+		key, err := ephemeralKey()
+		if err != nil {
+			return err
+		}
+		set.keys[key.kid] = key
+		set.signing = key
+	}
+	keys = set
+	return nil
+}
+
+func loadKey(k JWTKey) (*signingKey, error) {
+	key := &signingKey{kid: k.Kid}
+	if k.Kid == "" {
+		return nil, errors.New("kid is required")
+	}
+	if k.PrivateKey != "" {
+		data, err := os.ReadFile(k.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public, err = parsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := os.ReadFile(k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.public, err = parsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	method, err := signingMethodOf(key.public)
+	if err != nil {
+		return nil, err
+	}
+	key.method = method
+	return key, nil
+}
+
+func ephemeralKey() (*signingKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := RandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{kid: kid, method: SigningMethodEd25519, private: private, public: public}, nil
+}
+
+func (s *keySet) lookup(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || len(s.secret) == 0 {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(secret), nil
-	})
+		return s.secret, nil
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+func (s *keySet) sign(claims jwt.MapClaims) (string, error) {
+	if s.signing == nil {
+		if len(s.secret) == 0 {
+			return "", errors.New("no jwt signing key")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.signing.method, claims)
+	token.Header["kid"] = s.signing.kid
+	return token.SignedString(s.signing.private)
+}
+
+func parseToken(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, keys.lookup)
 	if err != nil {
 		return nil, err
 	}
 	if !token.Valid {
 		return nil, errors.New("invalid token")
 	}
-	return token.Claims.(jwt.MapClaims), nil
+	claims := token.Claims.(jwt.MapClaims)
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// DecodeToken verify tokenStr against the key named by its kid header,
+// tokens without exp claim and purpose tokens are rejected
+func DecodeToken(tokenStr string) (map[string]interface{}, error) {
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["pur"]; ok {
+		return nil, errors.New("invalid token")
+	}
+	if _, ok := claims["auth"]; !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// GeneratePurposeToken sign claims into a token only accepted by DecodePurposeToken with the same purpose
+func GeneratePurposeToken(purpose string, claims map[string]interface{}, ttl time.Duration) (string, error) {
+	now := time.Now()
+	signed := jwt.MapClaims{"pur": purpose, "iat": now.Unix(), "exp": now.Add(ttl).Unix()}
+	for k, v := range claims {
+		if _, ok := signed[k]; !ok {
+			signed[k] = v
+		}
+	}
+	return keys.sign(signed)
+}
+
+func DecodePurposeToken(tokenStr string, purpose string) (map[string]interface{}, error) {
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims["pur"] != purpose {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
 }
 
-func GenerateToken(secret string, auth map[string]interface{}) (string, error) {
-	expired := time.Now().Add(time.Hour * 24 * 30).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"auth": auth, "expire": expired,
+// GenerateToken sign auth into a token which expires after ttl,
+// expiration is checked by DecodeToken through the standard exp claim
+func GenerateToken(auth map[string]interface{}, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return keys.sign(jwt.MapClaims{
+		"auth": auth, "iat": now.Unix(), "exp": now.Add(ttl).Unix(),
 	})
-	tokenStr, err := token.SignedString([]byte(secret))
-	return tokenStr, err
 }